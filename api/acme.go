@@ -0,0 +1,180 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/baccenfutter/cion/backend"
+	"github.com/labstack/echo"
+	"github.com/miekg/dns"
+)
+
+type (
+	// acmeUpdate is a container for acme-dns update requests/responses.
+	acmeUpdate struct {
+		Subdomain string `json:"subdomain,omitempty"`
+		TXT       string `json:"txt"`
+	}
+
+	// acmeName serializes the updates of the challenge values of a name and
+	// holds the most recent one.
+	acmeName struct {
+		mu      sync.Mutex
+		latest  string
+		updated time.Time
+		// users counts the requests holding or waiting for mu, it is
+		// guarded by acmeMutex.
+		users int
+	}
+)
+
+const (
+	// acmeKeep is the number of challenge values kept per name.
+	acmeKeep = 2
+
+	// acmeExpiry is the time the most recent challenge value of a name is
+	// remembered, validations are long over then.
+	acmeExpiry = time.Hour
+)
+
+var (
+	// validACMEToken matches the base64url encoded SHA-256 digest which is
+	// published as DNS-01 challenge.
+	validACMEToken = regexp.MustCompile(`^[a-zA-Z0-9_\-]{43}$`)
+
+	// acmeNames holds the state of the names updated within acmeExpiry.
+	acmeMutex sync.Mutex
+	acmeNames = map[string]*acmeName{}
+)
+
+// lockACMEName returns the state of name, locked for the caller. It has to be
+// released with unlock.
+func lockACMEName(name string) *acmeName {
+	acmeMutex.Lock()
+	n, ok := acmeNames[name]
+	if !ok {
+		n = &acmeName{}
+		acmeNames[name] = n
+	}
+	n.users++
+	acmeMutex.Unlock()
+
+	n.mu.Lock()
+	return n
+}
+
+// unlock releases n. The states of names which are neither in use nor were
+// updated within acmeExpiry are dropped.
+func (n *acmeName) unlock() {
+	n.mu.Unlock()
+
+	acmeMutex.Lock()
+	defer acmeMutex.Unlock()
+	n.users--
+	for name, n := range acmeNames {
+		if n.users == 0 && time.Since(n.updated) >= acmeExpiry {
+			delete(acmeNames, name)
+		}
+	}
+}
+
+// acmeError responds with an error in the format of acme-dns.
+func acmeError(c echo.Context, code int, msg string) error {
+	return c.JSON(code, echo.Map{"error": msg})
+}
+
+// acmeUpdateTXT is the echo handler for acme-dns compatible updates, as used
+// by the acme-dns plugins of certbot, lego and Caddy. The zone and its auth
+// key are passed in the X-Api-User and X-Api-Key headers, the subdomain is
+// the name of the TXT record within the zone, e.g. _acme-challenge.www. It
+// defaults to _acme-challenge.
+//
+// Only the acmeKeep most recent challenge values of a name are kept, so that a
+// certificate for a name and its wildcard can be validated at once.
+// It returns
+//   - http200 and the published value if the update succeeded
+//   - http400 if the subdomain or the challenge value are invalid
+//   - http401 if the authentication failed
+//   - http403 if the subdomain is not within the scope of the key
+func acmeUpdateTXT(c echo.Context) error {
+	zone := c.Request().Header.Get("X-Api-User")
+	authKey := c.Request().Header.Get("X-Api-Key")
+	if zone == "" || authKey == "" {
+		return acmeError(c, http.StatusUnauthorized, "forbidden")
+	}
+	key, ok := keys.Identify(zone, []byte(authKey))
+	if !ok {
+		return acmeError(c, http.StatusUnauthorized, "forbidden")
+	}
+
+	if !allowUpdate(zone, key.ID) {
+		log.Printf("warning: client reached update limit: %s\n", clientAddr(c.Request()))
+		return acmeError(c, http.StatusTooManyRequests, "too_many_requests")
+	}
+
+	update := new(acmeUpdate)
+	if err := c.Bind(update); err != nil {
+		return acmeError(c, http.StatusBadRequest, "malformed_json_payload")
+	}
+
+	subdomain := update.Subdomain
+	if subdomain == "" {
+		subdomain = "_acme-challenge"
+	}
+	if !validACMEToken.MatchString(update.TXT) {
+		return acmeError(c, http.StatusBadRequest, "bad_txt")
+	}
+
+	txtParams := txtRecordParams{Name: subdomain, Value: update.TXT}
+	if !txtParams.isValid() {
+		return acmeError(c, http.StatusBadRequest, "bad_subdomain")
+	}
+	rr := txtParams.rr(zone)
+	name := rr.Header().Name
+	if checkWriteScope(key.Scope, zone, rr) != nil {
+		return acmeError(c, http.StatusForbidden, "forbidden")
+	}
+
+	// Updates of the same name are serialized, so that each of them knows
+	// the value of the one before.
+	n := lockACMEName(name)
+	defer n.unlock()
+
+	// The new value supersedes all but the previous one.
+	previous := n.latest
+	known := previous != "" && time.Since(n.updated) < acmeExpiry
+	cur, rrs, err := mergeRecord(zone, rr, func(old dns.RR) bool {
+		return known && strings.Join(old.(*dns.TXT).Txt, "") != previous
+	})
+	if err != nil {
+		return acmeError(c, http.StatusInternalServerError, "db_error")
+	}
+
+	// Keep the newest values, the new one comes first. If the previous value
+	// is not known, e.g. after a restart, the newest of several existing
+	// values can not be told, so only the new one is kept then.
+	newest := acmeKeep
+	if !known && len(rrs) > acmeKeep {
+		newest = 1
+	}
+	if len(rrs) > newest {
+		rrs = rrs[:newest]
+	}
+	rrs = applyTTL(cur, rrs)
+
+	err = store.Replace(recordName(zone), cur, rrs)
+	if err == backend.ErrConflict {
+		return acmeError(c, http.StatusConflict, "conflict")
+	} else if err != nil {
+		log.Println("error:", err)
+		return acmeError(c, http.StatusInternalServerError, "db_error")
+	}
+	n.latest = update.TXT
+	n.updated = time.Now()
+
+	return c.JSON(http.StatusOK, acmeUpdate{TXT: update.TXT})
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/baccenfutter/cion/authkey"
+	"github.com/baccenfutter/cion/backend"
+	"github.com/labstack/echo"
+)
+
+// setupTestKeys replaces the key store by one holding the key of testZone.
+// The returned function removes it again.
+func setupTestKeys(t *testing.T, key string) func() {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cion-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys = authkey.NewStore(dir)
+	if err := keys.Set(testZone, []byte(key)); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return func() { os.RemoveAll(dir) }
+}
+
+func TestACMEUpdateTXT(t *testing.T) {
+	defer setupTestKeys(t, "secret")()
+	store = backend.NewMemory()
+	acmeNames = map[string]*acmeName{}
+
+	update := func(value string) int {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/update", strings.NewReader(`{"subdomain":"_acme-challenge","txt":"`+value+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("X-Api-User", testZone)
+		req.Header.Set("X-Api-Key", "secret")
+		rec := httptest.NewRecorder()
+		if err := acmeUpdateTXT(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code
+	}
+	value := func(c byte) string {
+		return strings.Repeat(string(c), 43)
+	}
+	record := func(c byte) string {
+		return `_acme-challenge.example.cion.test. 180 IN TXT "` + value(c) + `"`
+	}
+
+	for _, c := range []byte("abc") {
+		if code := update(value(c)); code != http.StatusOK {
+			t.Fatalf("update %c: status %d", c, code)
+		}
+	}
+	assertZone(t, record('b'), record('c'))
+
+	// Without the previous value, the newest of the existing values is
+	// unknown.
+	acmeNames = map[string]*acmeName{}
+	if code := update(value('d')); code != http.StatusOK {
+		t.Fatalf("update d: status %d", code)
+	}
+	assertZone(t, record('d'))
+	if code := update(value('e')); code != http.StatusOK {
+		t.Fatalf("update e: status %d", code)
+	}
+	assertZone(t, record('d'), record('e'))
+
+	// The previous value is forgotten once it expired.
+	name := "_acme-challenge.example.cion.test."
+	acmeNames[name].updated = time.Now().Add(-acmeExpiry)
+	if code := update(value('f')); code != http.StatusOK {
+		t.Fatalf("update f: status %d", code)
+	}
+	assertZone(t, record('f'))
+
+	// Expired names are dropped.
+	acmeNames[name].updated = time.Now().Add(-acmeExpiry)
+	lockACMEName("other.example.cion.test.").unlock()
+	if len(acmeNames) != 0 {
+		t.Errorf("%d names kept, want none", len(acmeNames))
+	}
+}
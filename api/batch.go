@@ -0,0 +1,200 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	my_middleware "github.com/baccenfutter/cion/middleware"
+	"github.com/labstack/echo"
+	"github.com/miekg/dns"
+)
+
+// opBatch is reported for batches of operations.
+const opBatch = "batch"
+
+// Operations of a batch.
+const (
+	batchAdd     = "add"
+	batchDelete  = "delete"
+	batchReplace = "replace"
+)
+
+// batchMaxOperations limits the number of operations of a single batch.
+const batchMaxOperations = 100
+
+type (
+	// v2Operation is a single operation of a batch.
+	v2Operation struct {
+		Op      string     `json:"op"`
+		Type    string     `json:"type"`
+		Name    string     `json:"name"`
+		Records []v2Record `json:"records"`
+	}
+
+	// v2Batch is the body of batch requests.
+	v2Batch struct {
+		Operations []v2Operation `json:"operations"`
+	}
+
+	// batchOperation is a validated operation of a batch.
+	batchOperation struct {
+		op     string
+		rrtype uint16
+		owner  string
+		rrs    []dns.RR
+		glue   []dns.RR
+	}
+)
+
+// key returns the key of the RRset the operation applies to.
+func (o batchOperation) key() string {
+	return rrsetKey(o.owner, o.rrtype)
+}
+
+// parseBatchOperation validates a single operation of a batch.
+func parseBatchOperation(zone string, o v2Operation) (*batchOperation, error) {
+	rrtype, name, owner, err := parseRRset(zone, o.Type, o.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkManaged(rrtype, name); err != nil {
+		return nil, err
+	}
+	rrs, err := v2RRs(zone, rrtype, name, owner, o.Records)
+	if err != nil {
+		return nil, err
+	}
+
+	switch o.Op {
+	case batchAdd:
+		if len(rrs) == 0 {
+			return nil, newError(http.StatusBadRequest, errInvalidParameters, "no records to add given")
+		}
+	case batchReplace:
+		if len(rrs) == 0 {
+			return nil, newError(http.StatusBadRequest, errInvalidParameters, "no records given, use delete to remove the RRset")
+		}
+		if !exclusive(rrs) {
+			return nil, newError(http.StatusBadRequest, errInvalidParameters, "records exclude each other")
+		}
+	case batchDelete:
+		// Without records the entire RRset is deleted.
+	default:
+		return nil, newError(
+			http.StatusBadRequest,
+			errInvalidParameters,
+			fmt.Sprintf("invalid operation: %s", o.Op),
+		)
+	}
+
+	op := &batchOperation{op: o.Op, rrtype: rrtype, owner: owner, rrs: rrs}
+	if o.Op != batchDelete {
+		op.glue = v2Glue(rrtype, o.Records)
+	}
+	return op, nil
+}
+
+// applyBatch is the echo handler for applying several operations at once.
+// All operations are validated up front and applied in order within a single
+// transaction, so that either all of them or none take effect.
+// It returns
+//   - http202 and the resulting records of all affected RRsets
+//   - http400 if any of the operations is invalid
+//   - http404 if a record to delete does not exist
+//   - http409 if any of the RRsets was modified concurrently
+func applyBatch(c echo.Context) error {
+	cionHeaders := c.Get("cion_headers").(my_middleware.CionHeaders)
+	zone := cionHeaders.Zone
+
+	if !allowUpdate(cionHeaders.Zone, cionHeaders.KeyID) {
+		log.Printf("warning: client reached update limit: %s\n", clientAddr(c.Request()))
+		return newError(http.StatusTooManyRequests, errRateLimited, "one update per second with max burst of ten, please")
+	}
+
+	body := new(v2Batch)
+	if err := c.Bind(body); err != nil {
+		return newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
+	}
+	if len(body.Operations) == 0 {
+		return newError(http.StatusBadRequest, errInvalidParameters, "no operations given")
+	}
+	if len(body.Operations) > batchMaxOperations {
+		return newError(
+			http.StatusBadRequest,
+			errInvalidParameters,
+			fmt.Sprintf("at most %d operations per batch, please", batchMaxOperations),
+		)
+	}
+
+	ops := []*batchOperation{}
+	for i, o := range body.Operations {
+		op, err := parseBatchOperation(zone, o)
+		if err != nil {
+			// Point out the failing operation.
+			if he, ok := err.(*echo.HTTPError); ok {
+				if e, ok := he.Message.(apiError); ok {
+					e.Message = fmt.Sprintf("operation %d: %s", i, e.Message)
+					he.Message = e
+				}
+			}
+			return err
+		}
+		ops = append(ops, op)
+	}
+
+	// Read all affected RRsets, in the order they are first referred to.
+	order := []string{}
+	cur := map[string][]dns.RR{}
+	for _, op := range ops {
+		if _, ok := cur[op.key()]; ok {
+			continue
+		}
+		rrs, err := lookupRRset(zone, op.owner, op.rrtype)
+		if err != nil {
+			return err
+		}
+		order = append(order, op.key())
+		cur[op.key()] = rrs
+	}
+
+	next := map[string][]dns.RR{}
+	for key, rrs := range cur {
+		next[key] = rrs
+	}
+	for i, op := range ops {
+		switch op.op {
+		case batchAdd:
+			next[op.key()] = addRecords(next[op.key()], op.rrs)
+		case batchReplace:
+			next[op.key()] = op.rrs
+		case batchDelete:
+			if len(op.rrs) == 0 {
+				if len(next[op.key()]) == 0 {
+					return newError(http.StatusNotFound, errNotFound, fmt.Sprintf("operation %d: no such record", i))
+				}
+				next[op.key()] = nil
+				continue
+			}
+			rrs, ok := removeRecords(next[op.key()], op.rrs)
+			if !ok {
+				return newError(http.StatusNotFound, errNotFound, fmt.Sprintf("operation %d: no such record", i))
+			}
+			next[op.key()] = rrs
+		}
+	}
+
+	old, rrs, glue := []dns.RR{}, []dns.RR{}, []dns.RR{}
+	for _, key := range order {
+		old = append(old, cur[key]...)
+		rrs = append(rrs, next[key]...)
+	}
+	for _, op := range ops {
+		glue = append(glue, op.glue...)
+	}
+	old, rrs, err := delegate(zone, old, rrs, glue)
+	if err != nil {
+		return err
+	}
+	return replaceRecords(c, opBatch, zone, old, rrs)
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestApplyBatch(t *testing.T) {
+	initial := []string{
+		"example.cion.test. 180 IN SOA ns1.cion.test. hostmaster.cion.test. 1 3600 600 86400 180",
+		"example.cion.test. 180 IN NS ns1.cion.test.",
+		"www.example.cion.test. 180 IN A 192.0.2.1",
+		"old.example.cion.test. 180 IN A 192.0.2.2",
+	}
+	tests := []struct {
+		name string
+		body string
+		code int
+		want []string
+	}{
+		{
+			name: "all operations apply",
+			body: `{"operations":[
+				{"op":"replace","type":"A","name":"www","records":[{"address":"192.0.2.3"}]},
+				{"op":"add","type":"MX","name":"@","records":[{"preference":10,"exchange":"mail.example.org"}]},
+				{"op":"delete","type":"A","name":"old"}
+			]}`,
+			code: http.StatusAccepted,
+			want: []string{
+				initial[0], initial[1],
+				"www.example.cion.test. 180 IN A 192.0.2.3",
+				"example.cion.test. 180 IN MX 10 mail.example.org.",
+			},
+		},
+		{
+			name: "delete of the soa rolls back",
+			body: `{"operations":[
+				{"op":"replace","type":"A","name":"www","records":[{"address":"192.0.2.3"}]},
+				{"op":"delete","type":"SOA","name":"@"}
+			]}`,
+			code: http.StatusBadRequest,
+			want: initial,
+		},
+		{
+			name: "delete of the apex ns rolls back",
+			body: `{"operations":[
+				{"op":"delete","type":"A","name":"old"},
+				{"op":"delete","type":"NS","name":"@"}
+			]}`,
+			code: http.StatusBadRequest,
+			want: initial,
+		},
+		{
+			name: "missing record rolls back",
+			body: `{"operations":[
+				{"op":"delete","type":"A","name":"old"},
+				{"op":"delete","type":"A","name":"missing"}
+			]}`,
+			code: http.StatusNotFound,
+			want: initial,
+		},
+		{
+			name: "invalid record rolls back",
+			body: `{"operations":[
+				{"op":"add","type":"TXT","name":"@","records":[{"value":"ok"}]},
+				{"op":"add","type":"A","name":"www","records":[{"address":"2001:db8::1"}]}
+			]}`,
+			code: http.StatusBadRequest,
+			want: initial,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newRRsetContext(http.MethodPost, "", "", tt.body)
+			if err := store.Create(testApex, mustRRs(t, initial...)...); err != nil {
+				t.Fatal(err)
+			}
+
+			err := applyBatch(c)
+			if tt.code == http.StatusAccepted {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				assertStatus(t, err, tt.code)
+			}
+			assertZone(t, tt.want...)
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/baccenfutter/cion/backend"
+	"github.com/baccenfutter/cion/config"
+	"github.com/miekg/dns"
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	// certRenewBefore is the remaining validity at which certificates are
+	// renewed.
+	certRenewBefore = 30 * 24 * time.Hour
+
+	// certCheckInterval is the interval in which the certificate is checked
+	// for renewal, certRetryInterval the one after a failure.
+	certCheckInterval = 12 * time.Hour
+	certRetryInterval = time.Hour
+
+	// certTimeout limits the time to obtain a certificate.
+	certTimeout = 10 * time.Minute
+)
+
+// dnsCertManager obtains a wildcard certificate for the root domain from an
+// ACME CA. The DNS-01 challenges are solved by publishing their TXT records
+// in the root zone, which we serve ourselves.
+type dnsCertManager struct {
+	client  *acme.Client
+	email   string
+	zone    string
+	domains []string
+	dir     string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// newDNSCertManager returns a manager for the wildcard certificate of the
+// root domain, caching the account and the certificate in cfg.CertDir.
+func newDNSCertManager(cfg *config.Specification) (*dnsCertManager, error) {
+	if err := os.MkdirAll(cfg.CertDir, os.FileMode(0700)); err != nil {
+		return nil, err
+	}
+	m := &dnsCertManager{
+		email:   cfg.ACMEEmail,
+		zone:    dns.Fqdn(cfg.RootDomain),
+		domains: []string{cfg.RootDomain, "*." + cfg.RootDomain},
+		dir:     cfg.CertDir,
+	}
+
+	key, err := m.accountKey()
+	if err != nil {
+		return nil, err
+	}
+	m.client = &acme.Client{Key: key, DirectoryURL: cfg.ACMEDirectory}
+
+	// A cached certificate is served right away, even if it has expired,
+	// until it has been renewed.
+	if cert, err := tls.LoadX509KeyPair(m.certFile(), m.certFile()); err == nil {
+		m.cert = &cert
+	}
+	return m, nil
+}
+
+// GetCertificate returns the current certificate, it implements
+// tls.Config.GetCertificate.
+func (m *dnsCertManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return nil, errors.New("certificate not available yet")
+	}
+	return m.cert, nil
+}
+
+// Run obtains the certificate and renews it in time. It never returns.
+func (m *dnsCertManager) Run() {
+	for {
+		wait := certCheckInterval
+		if m.needsRenewal() {
+			log.Printf("Obtaining certificate for: %v\n", m.domains)
+			ctx, cancel := context.WithTimeout(context.Background(), certTimeout)
+			if err := m.obtain(ctx); err != nil {
+				log.Println("error: can not obtain certificate:", err)
+				wait = certRetryInterval
+			}
+			cancel()
+		}
+		time.Sleep(wait)
+	}
+}
+
+// needsRenewal reports whether there is no certificate yet or it expires
+// soon.
+func (m *dnsCertManager) needsRenewal() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil || len(m.cert.Certificate) == 0 {
+		return true
+	}
+	leaf, err := x509.ParseCertificate(m.cert.Certificate[0])
+	if err != nil {
+		return true
+	}
+	return time.Until(leaf.NotAfter) < certRenewBefore
+}
+
+// obtain orders a new certificate and solves all of its DNS-01 challenges.
+func (m *dnsCertManager) obtain(ctx context.Context) error {
+	account := &acme.Account{}
+	if m.email != "" {
+		account.Contact = []string{"mailto:" + m.email}
+	}
+	if _, err := m.client.Register(ctx, account, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return fmt.Errorf("register account: %s", err)
+	}
+
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(m.domains...))
+	if err != nil {
+		return fmt.Errorf("order: %s", err)
+	}
+
+	// The challenges of the domain and its wildcard share the same name.
+	name := dns.Fqdn("_acme-challenge." + m.domains[0])
+	published := []dns.RR{}
+	defer func() {
+		if err := m.unpublish(name, published); err != nil {
+			log.Println("warning: can not remove challenge records:", err)
+		}
+	}()
+
+	for _, url := range order.AuthzURLs {
+		authz, err := m.client.GetAuthorization(ctx, url)
+		if err != nil {
+			return fmt.Errorf("authorization: %s", err)
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+
+		var challenge *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == "dns-01" {
+				challenge = c
+			}
+		}
+		if challenge == nil {
+			return fmt.Errorf("no dns-01 challenge offered for %s", authz.Identifier.Value)
+		}
+
+		value, err := m.client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return err
+		}
+		rr := &dns.TXT{Hdr: recordHeader(name, dns.TypeTXT), Txt: []string{value}}
+		if err := store.Create(m.zone, rr); err != nil {
+			return fmt.Errorf("publish challenge: %s", err)
+		}
+		published = append(published, rr)
+
+		if _, err := m.client.Accept(ctx, challenge); err != nil {
+			return fmt.Errorf("accept challenge: %s", err)
+		}
+		if _, err := m.client.WaitAuthorization(ctx, authz.URI); err != nil {
+			return fmt.Errorf("challenge of %s: %s", authz.Identifier.Value, err)
+		}
+	}
+
+	// Polled orders lack their own URL, remember it.
+	orderURL := order.URI
+	order, err = m.client.WaitOrder(ctx, orderURL)
+	if err != nil {
+		return fmt.Errorf("order: %s", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: m.domains}, key)
+	if err != nil {
+		return err
+	}
+	chain, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		// Not every CA returns the URL of the order on finalization, which
+		// is needed to wait for the certificate. We know it anyway.
+		o, werr := m.client.WaitOrder(ctx, orderURL)
+		if werr != nil || o.CertURL == "" {
+			return fmt.Errorf("finalize order: %s", err)
+		}
+		chain, err = m.client.FetchCert(ctx, o.CertURL, true)
+		if err != nil {
+			return fmt.Errorf("fetch certificate: %s", err)
+		}
+	}
+
+	if err := m.save(chain, key); err != nil {
+		return err
+	}
+	log.Printf("Obtained certificate for: %v\n", m.domains)
+	return nil
+}
+
+// unpublish removes the challenge records.
+func (m *dnsCertManager) unpublish(name string, rrs []dns.RR) error {
+	if len(rrs) == 0 {
+		return nil
+	}
+	cur, err := store.Lookup(m.zone, name, dns.TypeTXT)
+	if err != nil {
+		return err
+	}
+	next, _ := removeRecords(cur, rrs)
+	err = store.Replace(m.zone, cur, next)
+	if err == backend.ErrConflict {
+		return store.Delete(m.zone, rrs...)
+	}
+	return err
+}
+
+// save caches the certificate and makes it the current one.
+func (m *dnsCertManager) save(chain [][]byte, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	for _, c := range chain {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+	}
+
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(m.certFile(), data, os.FileMode(0600)); err != nil {
+		log.Println("warning: can not cache certificate:", err)
+	}
+
+	m.mu.Lock()
+	m.cert = &cert
+	m.mu.Unlock()
+	return nil
+}
+
+// certFile is the file caching the key and the certificate chain.
+func (m *dnsCertManager) certFile() string {
+	return filepath.Join(m.dir, "wildcard.pem")
+}
+
+// accountKey loads the key of the ACME account, or generates one.
+func (m *dnsCertManager) accountKey() (crypto.Signer, error) {
+	path := filepath.Join(m.dir, "acme-account.pem")
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("can not read account key: %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(path, data, os.FileMode(0600)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
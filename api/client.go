@@ -0,0 +1,119 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	// trustedProxies holds the networks of the reverse proxies whose
+	// forwarding headers are honoured.
+	trustedProxies []*net.IPNet
+)
+
+// parseTrustedProxies parses a list of addresses and networks in CIDR
+// notation.
+func parseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy: %s", s)
+			}
+			if ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// isTrustedProxy reports whether ip belongs to a trusted reverse proxy.
+func isTrustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the client which sent r. If the request
+// was received from a trusted proxy, the address the proxy claims to have
+// received it from is used instead, following the chain of trusted proxies.
+// The headers Forwarded, X-Forwarded-For and X-Real-IP are honoured, in this
+// order. It returns nil if the address can not be determined.
+func clientAddr(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isTrustedProxy(ip) {
+		return ip
+	}
+
+	// All hops a request passed, from the client to the last proxy.
+	hops := []string{}
+	if len(r.Header["Forwarded"]) > 0 {
+		hops = forwardedFor(r.Header["Forwarded"])
+	} else if len(r.Header["X-Forwarded-For"]) > 0 {
+		for _, header := range r.Header["X-Forwarded-For"] {
+			hops = append(hops, splitList(header)...)
+		}
+	} else if v := r.Header.Get("X-Real-IP"); v != "" {
+		hops = []string{strings.TrimSpace(v)}
+	}
+
+	// Walk backwards through the hops as long as they are trusted, the
+	// first untrusted one is the client.
+	for i := len(hops) - 1; i >= 0 && isTrustedProxy(ip); i-- {
+		ip = parseHop(hops[i])
+		if ip == nil {
+			return nil
+		}
+	}
+	return ip
+}
+
+// forwardedFor returns the for parameters of RFC 7239 Forwarded headers.
+func forwardedFor(headers []string) []string {
+	hops := []string{}
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.ToLower(kv[0]) == "for" {
+					hops = append(hops, strings.Trim(kv[1], `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop parses an address as found in forwarding headers, which may carry
+// a port and brackets around IPv6 addresses.
+func parseHop(s string) net.IP {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.Trim(s, "[]"))
+}
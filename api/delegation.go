@@ -0,0 +1,134 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// delegate completes the replacement of the records old with rrs by the glue
+// of the delegations among them:
+//   - glue replaces the address records of the nameservers it belongs to
+//   - nameservers within the zone need addresses, given either as glue or
+//     published before
+//   - glue below a delegation is removed together with the last NS record
+//     referring to it
+//
+// It returns the completed lists of old and new records.
+func delegate(zone string, old, rrs, glue []dns.RR) ([]dns.RR, []dns.RR, error) {
+	apex := recordName(zone)
+
+	touched := map[string]bool{}
+	for _, rr := range append(append([]dns.RR{}, old...), rrs...) {
+		touched[rrsetKey(rr.Header().Name, rr.Header().Rrtype)] = true
+	}
+	targets := map[string]bool{}
+	for _, rr := range rrs {
+		if ns, ok := rr.(*dns.NS); ok {
+			targets[dns.CanonicalName(ns.Ns)] = true
+		}
+	}
+
+	// Replace the address RRsets of the nameservers with their glue.
+	sets := []string{}
+	byKey := map[string][]dns.RR{}
+	for _, rr := range glue {
+		name := rr.Header().Name
+		if !targets[dns.CanonicalName(name)] || !dns.IsSubDomain(apex, name) {
+			return nil, nil, newError(
+				http.StatusBadRequest,
+				errInvalidParameters,
+				"addresses can only be given for nameservers within the zone",
+			)
+		}
+		key := rrsetKey(name, rr.Header().Rrtype)
+		if _, ok := byKey[key]; !ok {
+			if touched[key] {
+				return nil, nil, newError(http.StatusBadRequest, errInvalidParameters, "records exclude each other")
+			}
+			sets = append(sets, key)
+		}
+		byKey[key] = append(byKey[key], rr)
+	}
+	for _, key := range sets {
+		first := byKey[key][0].Header()
+		cur, err := lookupRRset(zone, first.Name, first.Rrtype)
+		if err != nil {
+			return nil, nil, err
+		}
+		old = append(old, cur...)
+		rrs = append(rrs, byKey[key]...)
+		touched[key] = true
+	}
+
+	// Every nameserver within the zone has to be reachable.
+	for target := range targets {
+		if !dns.IsSubDomain(apex, target) {
+			continue
+		}
+		ok, err := hasAddress(zone, target, rrs, touched)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			return nil, nil, newError(
+				http.StatusBadRequest,
+				errInvalidParameters,
+				fmt.Sprintf("nameserver %s lies within the zone, please specify its addresses", target),
+			)
+		}
+	}
+
+	// Glue below a delegation is of no use without it.
+	for _, rr := range old {
+		ns, ok := rr.(*dns.NS)
+		if !ok || targets[dns.CanonicalName(ns.Ns)] || !dns.IsSubDomain(ns.Hdr.Name, ns.Ns) {
+			continue
+		}
+		for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			key := rrsetKey(ns.Ns, rrtype)
+			if touched[key] {
+				continue
+			}
+			cur, err := lookupRRset(zone, ns.Ns, rrtype)
+			if err != nil {
+				return nil, nil, err
+			}
+			old = append(old, cur...)
+			touched[key] = true
+		}
+	}
+
+	return old, rrs, nil
+}
+
+// hasAddress reports whether name has an address record after the
+// replacement. RRsets which are touched by it are not looked up.
+func hasAddress(zone, name string, rrs []dns.RR, touched map[string]bool) (bool, error) {
+	for _, rr := range rrs {
+		t := rr.Header().Rrtype
+		if (t == dns.TypeA || t == dns.TypeAAAA) && strings.EqualFold(rr.Header().Name, name) {
+			return true, nil
+		}
+	}
+	for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		if touched[rrsetKey(name, rrtype)] {
+			continue
+		}
+		cur, err := lookupRRset(zone, name, rrtype)
+		if err != nil {
+			return false, err
+		}
+		if len(cur) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rrsetKey returns the key of the RRset with the given owner name and type.
+func rrsetKey(name string, rrtype uint16) string {
+	return dns.CanonicalName(name) + "/" + dns.TypeToString[rrtype]
+}
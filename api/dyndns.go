@@ -0,0 +1,156 @@
+package api
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/baccenfutter/cion/authkey"
+	"github.com/labstack/echo"
+	"github.com/miekg/dns"
+)
+
+// Return codes of the dyndns2 protocol.
+const (
+	dyndnsGood     = "good"
+	dyndnsNoChange = "nochg"
+	dyndnsBadAuth  = "badauth"
+	dyndnsNoHost   = "nohost"
+	dyndnsNotFQDN  = "notfqdn"
+	dyndnsNumHost  = "numhost"
+	dyndnsAbuse    = "abuse"
+	dyndnsError    = "911"
+)
+
+// dyndnsMaxHosts limits the number of hostnames updated by a single request.
+const dyndnsMaxHosts = 20
+
+// dyndnsUpdate is the echo handler for the dyndns2 protocol, as spoken by
+// most routers and ddclient. The zone and its auth key are passed as HTTP
+// basic auth credentials, the hostnames and addresses as the comma separated
+// query parameters hostname and myip. Without myip the address of the client
+// is used.
+//
+// Every address is published as the only A or AAAA record of every hostname.
+// The response contains one return code per hostname, e.g.
+//   - good <address>  if the records were updated
+//   - nochg <address> if the records were already up to date
+//   - nohost          if the hostname is not within the zone
+//   - badauth         if the authentication failed
+func dyndnsUpdate(c echo.Context) error {
+	zone, authKey, ok := c.Request().BasicAuth()
+	var key authkey.Info
+	if ok {
+		key, ok = keys.Identify(zone, []byte(authKey))
+	}
+	if !ok {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="cion"`)
+		return c.String(http.StatusUnauthorized, dyndnsBadAuth+"\n")
+	}
+
+	if !allowUpdate(zone, key.ID) {
+		log.Printf("warning: client reached update limit: %s\n", clientAddr(c.Request()))
+		return c.String(http.StatusOK, dyndnsAbuse+"\n")
+	}
+
+	hostnames := splitList(c.QueryParam("hostname"))
+	if len(hostnames) == 0 {
+		return c.String(http.StatusOK, dyndnsNotFQDN+"\n")
+	}
+	if len(hostnames) > dyndnsMaxHosts {
+		return c.String(http.StatusOK, dyndnsNumHost+"\n")
+	}
+
+	addrs := splitList(c.QueryParam("myip"))
+	if len(addrs) == 0 {
+		ip := clientAddr(c.Request())
+		if ip == nil {
+			return c.String(http.StatusOK, dyndnsError+"\n")
+		}
+		addrs = []string{ip.String()}
+	}
+
+	out := []string{}
+	for _, hostname := range hostnames {
+		out = append(out, dyndnsUpdateHost(zone, key.Scope, hostname, addrs))
+	}
+	return c.String(http.StatusOK, strings.Join(out, "\n")+"\n")
+}
+
+// dyndnsUpdateHost publishes addrs as the addresses of hostname and returns
+// the dyndns2 return code. Hostnames outside of the scope of the key are
+// reported as nohost.
+func dyndnsUpdateHost(zone string, scope *authkey.Scope, hostname string, addrs []string) string {
+	if _, ok := dns.IsDomainName(hostname); !ok || !strings.Contains(hostname, ".") {
+		return dyndnsNotFQDN
+	}
+
+	// The hostname has to be a direct child of the zone, just like the
+	// names of the records created via the regular API.
+	name := strings.TrimSuffix(dns.CanonicalName(hostname), "."+dns.CanonicalName(recordName(zone)))
+	if name == dns.CanonicalName(hostname) {
+		return dyndnsNoHost
+	}
+
+	// Every hostname has at most one address of each family.
+	var a, aaaa dns.RR
+	published := []string{}
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return dyndnsError
+		}
+		if ip.To4() != nil && a == nil {
+			aParams := aRecordParams{Name: name, Addr: ip.To4().String()}
+			if !aParams.isValid() {
+				return dyndnsNoHost
+			}
+			a = aParams.rr(zone)
+			published = append(published, aParams.Addr)
+		} else if ip.To4() == nil && aaaa == nil {
+			aaaaParams := aaaaRecordParams{Name: name, Addr: ip.String()}
+			if !aaaaParams.isValid() {
+				return dyndnsNoHost
+			}
+			aaaa = aaaaParams.rr(zone)
+			published = append(published, aaaaParams.Addr)
+		}
+	}
+
+	code := dyndnsNoChange
+	for _, rr := range []dns.RR{a, aaaa} {
+		if rr == nil {
+			continue
+		}
+		if checkWriteScope(scope, zone, rr) != nil {
+			return dyndnsNoHost
+		}
+		cur, next, err := mergeRecord(zone, rr, supersedes(rr))
+		if err != nil {
+			return dyndnsError
+		}
+		next = applyTTL(cur, next)
+		if len(cur) == 1 && dns.IsDuplicate(cur[0], rr) {
+			continue
+		}
+		if err := store.Replace(recordName(zone), cur, next); err != nil {
+			log.Println("error:", err)
+			return dyndnsError
+		}
+		code = dyndnsGood
+	}
+	return code + " " + strings.Join(published, ",")
+}
+
+// splitList splits a comma separated query parameter, ignoring empty items.
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/baccenfutter/cion/authkey"
+	my_middleware "github.com/baccenfutter/cion/middleware"
+	"github.com/labstack/echo"
+)
+
+const (
+	// keyDefaultGrace is the time the old key remains valid after a rotation,
+	// unless the request asks for another grace period.
+	keyDefaultGrace = 24 * time.Hour
+
+	// keyMaxGrace limits the grace period of rotations.
+	keyMaxGrace = 30 * 24 * time.Hour
+)
+
+var validKeyLabel = regexp.MustCompile(`^[a-zA-Z0-9_.\-]{1,64}$`)
+
+type (
+	// keyInfo describes a key of a zone, without the key itself.
+	keyInfo struct {
+		authkey.Info
+		// Current marks the key the request was authenticated with.
+		Current bool `json:"current,omitempty"`
+	}
+
+	// keyListResponse lists the valid keys of a zone.
+	keyListResponse struct {
+		Zone string    `json:"zone"`
+		Keys []keyInfo `json:"keys"`
+	}
+
+	// keyResponse is returned for newly created keys. The plain key is only
+	// ever handed out once, in AuthKey.
+	keyResponse struct {
+		Zone    string   `json:"zone"`
+		Key     keyInfo  `json:"key"`
+		AuthKey string   `json:"auth_key"`
+		Revokes *keyInfo `json:"revokes,omitempty"`
+	}
+
+	// keyParams is the body of requests creating a key. A key with a scope
+	// is restricted to it, ExpiresIn is a duration like "720h" after which
+	// the key expires.
+	keyParams struct {
+		Label     string       `json:"label" form:"label" query:"label"`
+		Scope     *scopeParams `json:"scope"`
+		ExpiresIn string       `json:"expires_in" form:"expires_in" query:"expires_in"`
+	}
+
+	// rotateParams is the body of rotation requests. Grace is a duration like
+	// "1h30m", the time the old key remains valid.
+	rotateParams struct {
+		Grace string `json:"grace" form:"grace" query:"grace"`
+	}
+)
+
+// keyRequest applies the rate limit to requests managing the keys of a zone.
+// Only permanent keys with full access may manage keys, expiring keys and
+// access tokens may not.
+func keyRequest(c echo.Context) (my_middleware.CionHeaders, error) {
+	cionHeaders := c.Get("cion_headers").(my_middleware.CionHeaders)
+	if !allowUpdate(cionHeaders.Zone, cionHeaders.KeyID) {
+		log.Printf("warning: client reached update limit: %s\n", clientAddr(c.Request()))
+		return cionHeaders, newError(http.StatusTooManyRequests, errRateLimited, "one update per second with max burst of ten, please")
+	}
+	return cionHeaders, requireOwner(c)
+}
+
+// newKeyInfo marks the info of the key the request was authenticated with.
+func newKeyInfo(cionHeaders my_middleware.CionHeaders, info authkey.Info) keyInfo {
+	return keyInfo{Info: info, Current: info.ID == cionHeaders.KeyID}
+}
+
+// zoneKeys lists the valid keys of the zone of the request.
+func zoneKeys(cionHeaders my_middleware.CionHeaders) keyListResponse {
+	res := keyListResponse{Zone: cionHeaders.Zone, Keys: []keyInfo{}}
+	for _, info := range keys.Keys(cionHeaders.Zone) {
+		res.Keys = append(res.Keys, newKeyInfo(cionHeaders, info))
+	}
+	return res
+}
+
+// listKeys is the echo handler for listing the keys of a zone.
+// It returns
+//   - http200 and the valid keys of the zone
+func listKeys(c echo.Context) error {
+	cionHeaders, err := keyRequest(c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, zoneKeys(cionHeaders))
+}
+
+// createKey is the echo handler for issuing an additional key for a zone,
+// optionally restricted to a scope and expiring.
+// It returns
+//   - http202 and the new key
+//   - http400 if the label, the scope or the expiry are invalid
+//   - http403 unless the key of the request is permanent and has full access
+func createKey(c echo.Context) error {
+	cionHeaders, err := keyRequest(c)
+	if err != nil {
+		return err
+	}
+
+	params := new(keyParams)
+	if err := c.Bind(params); err != nil {
+		return newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
+	}
+	if !validKeyLabel.MatchString(params.Label) {
+		return newError(http.StatusBadRequest, errInvalidParameters, "invalid label")
+	}
+	scope, err := parseScope(params.Scope)
+	if err != nil {
+		return err
+	}
+	var expires *time.Time
+	if params.ExpiresIn != "" {
+		d, err := time.ParseDuration(params.ExpiresIn)
+		if err != nil || d <= 0 {
+			return newError(http.StatusBadRequest, errInvalidParameters, "invalid expiry")
+		}
+		at := time.Now().Add(d).UTC().Truncate(time.Second)
+		expires = &at
+	}
+
+	key, err := newAuthKey()
+	if err != nil {
+		return err
+	}
+	info, err := keys.Add(cionHeaders.Zone, []byte(key), authkey.Info{
+		Label:   params.Label,
+		Expires: expires,
+		Scope:   scope,
+	})
+	if err != nil {
+		log.Println("error: can not persist key:", err)
+		return newError(http.StatusInternalServerError, errInternal, "can not create key")
+	}
+	log.Printf("Created key %s of zone: %s\n", info.ID, cionHeaders.Zone)
+
+	return c.JSON(http.StatusAccepted, keyResponse{
+		Zone:    cionHeaders.Zone,
+		Key:     newKeyInfo(cionHeaders, info),
+		AuthKey: key,
+	})
+}
+
+// revokeKey is the echo handler for revoking a key of a zone.
+// It returns
+//   - http202 and the remaining keys of the zone
+//   - http404 if the zone has no such key
+//   - http409 if it is the last permanent key with full access of the zone
+func revokeKey(c echo.Context) error {
+	cionHeaders, err := keyRequest(c)
+	if err != nil {
+		return err
+	}
+
+	err = keys.Revoke(cionHeaders.Zone, c.Param("id"))
+	if err == authkey.ErrNotFound {
+		return newError(http.StatusNotFound, errNotFound, "no such key")
+	} else if err == authkey.ErrLastKey {
+		return newError(http.StatusConflict, errConflict, "can not revoke the last permanent key with full access, delete the zone instead")
+	} else if err != nil {
+		log.Println("error: can not revoke key:", err)
+		return newError(http.StatusInternalServerError, errInternal, "can not revoke key")
+	}
+	log.Printf("Revoked key %s of zone: %s\n", c.Param("id"), cionHeaders.Zone)
+
+	return c.JSON(http.StatusAccepted, zoneKeys(cionHeaders))
+}
+
+// rotateKey is the echo handler for replacing a key of a zone. A new key with
+// the same label is issued, the old one remains valid for a grace period, so
+// that clients can be switched over without downtime.
+// It returns
+//   - http202 and the new key, plus the key it revokes
+//   - http400 if the grace period is invalid
+//   - http403 unless the key of the request is permanent and has full access
+//   - http404 if the zone has no such key
+func rotateKey(c echo.Context) error {
+	cionHeaders, err := keyRequest(c)
+	if err != nil {
+		return err
+	}
+
+	// All parameters are optional, so is the body.
+	params := new(rotateParams)
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(params); err != nil {
+			return newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
+		}
+	}
+	grace := keyDefaultGrace
+	if params.Grace != "" {
+		grace, err = time.ParseDuration(params.Grace)
+		if err != nil || grace < 0 {
+			return newError(http.StatusBadRequest, errInvalidParameters, "invalid grace period")
+		}
+	}
+	if grace > keyMaxGrace {
+		return newError(
+			http.StatusBadRequest,
+			errInvalidParameters,
+			fmt.Sprintf("grace period must not exceed %s", keyMaxGrace),
+		)
+	}
+
+	var old *authkey.Info
+	for _, info := range keys.Keys(cionHeaders.Zone) {
+		if info.ID == c.Param("id") {
+			old = &info
+			break
+		}
+	}
+	if old == nil {
+		return newError(http.StatusNotFound, errNotFound, "no such key")
+	}
+
+	key, err := newAuthKey()
+	if err != nil {
+		return err
+	}
+	// The new key inherits the label, scope and expiry of the old one.
+	info, err := keys.Add(cionHeaders.Zone, []byte(key), authkey.Info{
+		Label:   old.Label,
+		Expires: old.Expires,
+		Scope:   old.Scope,
+	})
+	if err != nil {
+		log.Println("error: can not persist key:", err)
+		return newError(http.StatusInternalServerError, errInternal, "can not create key")
+	}
+	revoked, err := keys.Expire(cionHeaders.Zone, old.ID, time.Now().Add(grace))
+	if err == authkey.ErrNotFound {
+		// The old key expired or was revoked in the meantime.
+		return c.JSON(http.StatusAccepted, keyResponse{
+			Zone:    cionHeaders.Zone,
+			Key:     newKeyInfo(cionHeaders, info),
+			AuthKey: key,
+		})
+	} else if err != nil {
+		log.Println("error: can not expire key:", err)
+		return newError(http.StatusInternalServerError, errInternal, "can not revoke key")
+	}
+	log.Printf("Rotated key %s of zone %s to: %s\n", old.ID, cionHeaders.Zone, info.ID)
+
+	revokes := newKeyInfo(cionHeaders, revoked)
+	return c.JSON(http.StatusAccepted, keyResponse{
+		Zone:    cionHeaders.Zone,
+		Key:     newKeyInfo(cionHeaders, info),
+		AuthKey: key,
+		Revokes: &revokes,
+	})
+}
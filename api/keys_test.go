@@ -0,0 +1,55 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/baccenfutter/cion/authkey"
+	my_middleware "github.com/baccenfutter/cion/middleware"
+	"github.com/labstack/echo"
+)
+
+// newKeyContext returns the context of a key management request of testZone,
+// authenticated with the key with the given ID.
+func newKeyContext(keyID, id, body string) echo.Context {
+	c, _ := newTestContext(my_middleware.CionHeaders{KeyID: keyID})
+	req := c.Request()
+	req.Body = ioutil.NopCloser(strings.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c.SetParamNames("zone", "id")
+	c.SetParamValues(testZone, id)
+	return c
+}
+
+func TestKeyManagementOwner(t *testing.T) {
+	defer setupTestKeys(t, "secret")()
+	owner := keys.Keys(testZone)[0]
+	expires := time.Now().Add(time.Hour)
+	expiring, err := keys.Add(testZone, []byte("expiring"), authkey.Info{Label: "ci", Expires: &expires})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A key with full access, which expires, e.g. during its grace period
+	// after a rotation, can not issue itself a permanent key.
+	assertStatus(t, createKey(newKeyContext(expiring.ID, "", `{"label": "laptop"}`)), http.StatusForbidden)
+	assertStatus(t, rotateKey(newKeyContext(expiring.ID, expiring.ID, "")), http.StatusForbidden)
+	assertStatus(t, rotateKey(newKeyContext(expiring.ID, owner.ID, "")), http.StatusForbidden)
+	if n := len(keys.Keys(testZone)); n != 2 {
+		t.Fatalf("%d keys, want 2", n)
+	}
+
+	if err := createKey(newKeyContext(owner.ID, "", `{"label": "laptop"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rotateKey(newKeyContext(owner.ID, expiring.ID, "")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(keys.Keys(testZone)); n != 4 {
+		t.Fatalf("%d keys, want 4", n)
+	}
+}
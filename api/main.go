@@ -4,9 +4,13 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
-	"strings"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
+	"github.com/baccenfutter/cion/authkey"
+	"github.com/baccenfutter/cion/backend"
+	"github.com/baccenfutter/cion/config"
 	my_middleware "github.com/baccenfutter/cion/middleware"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
@@ -15,40 +19,83 @@ import (
 var (
 	// CionKeyDir holds the path where all keys are stored.
 	CionKeyDir = "/etc/bind/keys"
+
+	// keys holds the auth keys of all zones.
+	keys *authkey.Store
+
+	// tokens issues and verifies the access tokens for the keys.
+	tokens *authkey.Tokens
 )
 
-// LoadKeys loads all keys from disk to memory.
+// LoadKeys loads all keys from disk to memory. The keys are reloaded whenever
+// the key directory changes and on SIGHUP. The secrets access tokens are
+// signed with and the signing keys are sealed with are loaded as well.
 func LoadKeys() {
+	cfg := config.Config()
+	CionKeyDir = cfg.KeyDir
+
+	// Make sure new registrations can be persisted.
 	file, err := ioutil.TempFile(CionKeyDir, ".tmp")
-	defer os.Remove(file.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	file.Close()
+	os.Remove(file.Name())
 
+	for _, path := range []string{cfg.TokenSecretFile, cfg.SigningSecretFile} {
+		if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0700)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	signingSecret, err := authkey.LoadSecret(cfg.SigningSecretFile)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	out, err := exec.Command("cion_list_keys").Output()
+	keys = authkey.NewStore(CionKeyDir)
+	if err := keys.SetSigningSecret(signingSecret); err != nil {
+		log.Fatal(err)
+	}
+	n, err := keys.Load()
 	if err != nil {
 		log.Fatal(err)
 	}
+	log.Printf("Loaded %d keys.\n", n)
 
-	var i int
-	var line string
-	for i, line = range strings.Split(string(out), "\n") {
-		if line == "" {
-			continue
-		}
-		pairs := strings.Split(line, " ")
-		if len(pairs) != 2 {
-			log.Fatal("can not read key:", line)
-		}
+	tokens, err = authkey.LoadTokens(cfg.TokenSecretFile, cfg.TokenTTL)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	log.Printf("Loaded %d keys.\n", i)
+	go keys.Watch(cfg.KeyRefresh)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			n, err := keys.Load()
+			if err != nil {
+				log.Println("warning: can not reload keys:", err)
+				continue
+			}
+			log.Printf("Reloaded %d keys.\n", n)
+		}
+	}()
 }
 
-// ListenAndServe starts and runs the HTTP server.
-func ListenAndServe() {
+// ListenAndServe starts and runs the HTTP server on top of the given record
+// backend.
+func ListenAndServe(b backend.Backend) {
+	store = b
+
+	var err error
+	trustedProxies, err = parseTrustedProxies(config.Config().TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	e := echo.New()
+	e.HTTPErrorHandler = errorHandler(e)
 	e.Static("/static", "/public/static")
 
 	e.Use(middleware.Logger())
@@ -60,13 +107,46 @@ func ListenAndServe() {
 		return c.Attachment("/public/cion-tool.sh", "cion-tool.sh")
 	})
 	e.PUT("/register", createZone)
+	e.GET("/nic/update", dyndnsUpdate)
+	e.POST("/update", acmeUpdateTXT)
+
+	cion := my_middleware.CionWithConfig(my_middleware.CionConfig{
+		Keys:    keys,
+		Tokens:  tokens,
+		MaxSkew: config.Config().SignatureSkew,
+	})
 
 	g := e.Group("/zone",
-		my_middleware.Cion(),
+		cion,
 		my_middleware.Version(),
 	)
 	g.POST("/:zone", createUpdateOrDeleteRecord)
 	g.GET("/:zone", getRecordList)
+	g.DELETE("/:zone", deleteZone)
+	g.GET("/:zone/keys", listKeys)
+	g.POST("/:zone/keys", createKey)
+	g.DELETE("/:zone/keys/:id", revokeKey)
+	g.POST("/:zone/keys/:id/rotate", rotateKey)
+	g.POST("/:zone/token", createToken)
+
+	v2 := e.Group("/v2/zones",
+		cion,
+		my_middleware.VersionWithConfig(my_middleware.VersionConfig{
+			AllowedVersionPattern: ">=2.0.0 <3.0.0",
+		}),
+	)
+	v2.DELETE("/:zone", deleteZone)
+	v2.GET("/:zone/records", getRecordList)
+	v2.GET("/:zone/records/:type/:name", getRRset)
+	v2.PUT("/:zone/records/:type/:name", putRRset)
+	v2.PATCH("/:zone/records/:type/:name", patchRRset)
+	v2.DELETE("/:zone/records/:type/:name", deleteRRset)
+	v2.POST("/:zone/batch", applyBatch)
+	v2.GET("/:zone/keys", listKeys)
+	v2.POST("/:zone/keys", createKey)
+	v2.DELETE("/:zone/keys/:id", revokeKey)
+	v2.POST("/:zone/keys/:id/rotate", rotateKey)
+	v2.POST("/:zone/token", createToken)
 
-	e.Logger.Fatal(e.Start(":80"))
+	e.Logger.Fatal(serve(e))
 }
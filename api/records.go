@@ -0,0 +1,248 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/baccenfutter/cion/backend"
+	"github.com/baccenfutter/cion/config"
+	my_middleware "github.com/baccenfutter/cion/middleware"
+	"github.com/labstack/echo"
+	"github.com/miekg/dns"
+)
+
+var (
+	// store is the backend all records are read from and written to.
+	store backend.Backend
+)
+
+// recordName returns the fully qualified domain name of the given labels
+// within zone. Without labels the name of the zone itself is returned.
+func recordName(zone string, labels ...string) string {
+	parts := append([]string{}, labels...)
+	parts = append(parts, zone, config.Config().RootDomain)
+	return dns.Fqdn(strings.Join(parts, "."))
+}
+
+// recordHeader returns the header for a new record of the given name and type.
+func recordHeader(name string, rrtype uint16) dns.RR_Header {
+	return dns.RR_Header{
+		Name:   name,
+		Rrtype: rrtype,
+		Class:  dns.ClassINET,
+		Ttl:    uint32(config.Config().TTL),
+	}
+}
+
+// ttlHeader returns the header for a new record of the given name and type
+// with the requested TTL, limited to the configured bounds. Without a TTL,
+// zero, the record gets the TTL of its RRset, see applyTTL.
+func ttlHeader(name string, rrtype uint16, ttl uint32) dns.RR_Header {
+	hdr := recordHeader(name, rrtype)
+	hdr.Ttl = clampTTL(ttl)
+	return hdr
+}
+
+// clampTTL limits a requested TTL to the configured bounds. Zero, no TTL
+// requested, is retained.
+func clampTTL(ttl uint32) uint32 {
+	cfg := config.Config()
+	if ttl == 0 {
+		return 0
+	}
+	if ttl < uint32(cfg.MinTTL) {
+		return uint32(cfg.MinTTL)
+	}
+	if cfg.MaxTTL != 0 && ttl > uint32(cfg.MaxTTL) {
+		return uint32(cfg.MaxTTL)
+	}
+	return ttl
+}
+
+// applyTTL gives all records of each RRset in rrs the same TTL, as required
+// by RFC 2181: the TTL requested for the new records, otherwise the one of
+// the existing RRset in cur or the default. Records of cur are copied rather
+// than modified.
+func applyTTL(cur, rrs []dns.RR) []dns.RR {
+	existing := map[dns.RR]bool{}
+	current := map[string]uint32{}
+	for _, rr := range cur {
+		existing[rr] = true
+		key := rrsetKey(rr.Header().Name, rr.Header().Rrtype)
+		if _, ok := current[key]; !ok {
+			current[key] = rr.Header().Ttl
+		}
+	}
+	requested := map[string]uint32{}
+	for _, rr := range rrs {
+		key := rrsetKey(rr.Header().Name, rr.Header().Rrtype)
+		if _, ok := requested[key]; !ok && !existing[rr] && rr.Header().Ttl != 0 {
+			requested[key] = rr.Header().Ttl
+		}
+	}
+
+	next := []dns.RR{}
+	for _, rr := range rrs {
+		key := rrsetKey(rr.Header().Name, rr.Header().Rrtype)
+		ttl, ok := requested[key]
+		if !ok {
+			ttl, ok = current[key]
+		}
+		if !ok {
+			ttl = uint32(config.Config().TTL)
+		}
+		if rr.Header().Ttl != ttl {
+			if existing[rr] {
+				rr = dns.Copy(rr)
+			}
+			rr.Header().Ttl = ttl
+		}
+		next = append(next, rr)
+	}
+	return next
+}
+
+// updateRecord adds rr to its RRset. All existing records of the RRset which
+// are superseded by rr are removed in the same transaction.
+func updateRecord(c echo.Context, zone string, rr dns.RR) error {
+	cur, rrs, err := mergeRecord(zone, rr, supersedes(rr))
+	if err != nil {
+		return err
+	}
+	return replaceRecords(c, opUpdate, zone, cur, rrs)
+}
+
+// mergeRecord returns the current RRset of rr and the RRset resulting from
+// adding rr to it, without those records for which replaced returns true.
+func mergeRecord(zone string, rr dns.RR, replaced func(dns.RR) bool) (cur, rrs []dns.RR, err error) {
+	cur, err = store.Lookup(recordName(zone), rr.Header().Name, rr.Header().Rrtype)
+	if err != nil {
+		log.Println("error:", err)
+		return nil, nil, newError(http.StatusInternalServerError, errStoreUnavailable, "record store not available")
+	}
+
+	rrs = []dns.RR{rr}
+	for _, old := range cur {
+		if replaced(old) || dns.IsDuplicate(old, rr) {
+			continue
+		}
+		rrs = append(rrs, old)
+	}
+	return cur, rrs, nil
+}
+
+// supersedes returns the function reporting which existing records of its
+// RRset are replaced by rr, rather than complemented.
+func supersedes(rr dns.RR) func(dns.RR) bool {
+	switch rr := rr.(type) {
+	case *dns.MX:
+		// An MX record replaces the one with the same preference.
+		return func(old dns.RR) bool {
+			return old.(*dns.MX).Preference == rr.Preference
+		}
+	case *dns.SRV:
+		// An SRV record replaces the one with the same priority and weight.
+		return func(old dns.RR) bool {
+			srv := old.(*dns.SRV)
+			return srv.Priority == rr.Priority && srv.Weight == rr.Weight
+		}
+	case *dns.CAA:
+		// A CAA record replaces the one with the same tag and value, only
+		// its flags can change.
+		return func(old dns.RR) bool {
+			caa := old.(*dns.CAA)
+			return strings.EqualFold(caa.Tag, rr.Tag) && caa.Value == rr.Value
+		}
+	case *dns.SSHFP:
+		// A host has a single key per algorithm, its fingerprint of the same
+		// type is replaced.
+		return func(old dns.RR) bool {
+			sshfp := old.(*dns.SSHFP)
+			return sshfp.Algorithm == rr.Algorithm && sshfp.Type == rr.Type
+		}
+	case *dns.SVCB, *dns.HTTPS:
+		// An alias can not be combined with other records of the RRset,
+		// otherwise a record replaces the one with the same priority and
+		// target.
+		svcb := svcbData(rr)
+		return func(old dns.RR) bool {
+			o := svcbData(old)
+			if svcb.Priority == 0 || o.Priority == 0 {
+				return true
+			}
+			return o.Priority == svcb.Priority && strings.EqualFold(o.Target, svcb.Target)
+		}
+	case *dns.TLSA:
+		// Several certificates are published during rollovers.
+		return func(dns.RR) bool { return false }
+	case *dns.TXT:
+		// TXT records are only ever added, an identical value is not
+		// duplicated.
+		return func(dns.RR) bool { return false }
+	case *dns.NS:
+		// A name can be delegated to several nameservers.
+		return func(dns.RR) bool { return false }
+	}
+	// There is only a single address per hostname, and a name can only ever
+	// be an alias for exactly one other name.
+	return func(dns.RR) bool { return true }
+}
+
+// deleteRecord removes rr from its RRset.
+func deleteRecord(c echo.Context, zone string, rr dns.RR) error {
+	cur, err := store.Lookup(recordName(zone), rr.Header().Name, rr.Header().Rrtype)
+	if err != nil {
+		log.Println("error:", err)
+		return newError(http.StatusInternalServerError, errStoreUnavailable, "record store not available")
+	}
+
+	rrs := []dns.RR{}
+	for _, old := range cur {
+		if !dns.IsDuplicate(old, rr) {
+			rrs = append(rrs, old)
+		}
+	}
+	if len(rrs) == len(cur) {
+		return newError(http.StatusNotFound, errNotFound, "no such record")
+	}
+
+	return replaceRecords(c, opDelete, zone, cur, rrs)
+}
+
+// replaceRecords replaces the RRset cur with rrs and responds with the
+// resulting RRset and the difference to cur. Nothing is sent to the store if
+// the RRset is unchanged or a dry-run was requested. The key of the request
+// has to be permitted to modify all records of both RRsets.
+func replaceRecords(c echo.Context, op, zone string, cur, rrs []dns.RR) error {
+	cionHeaders, _ := c.Get("cion_headers").(my_middleware.CionHeaders)
+	rrs = applyTTL(cur, rrs)
+	touched := append(append([]dns.RR{}, cur...), rrs...)
+	if err := checkWriteScope(cionHeaders.Scope, zone, touched...); err != nil {
+		return err
+	}
+
+	diff := newDiff(cur, rrs)
+	res := recordResponse{
+		Operation: op,
+		Zone:      zone,
+		Changed:   diff.changed(),
+		DryRun:    cionHeaders.DryRun,
+		Diff:      diff,
+		Records:   newRecords(rrs),
+	}
+	if res.DryRun {
+		return c.JSON(http.StatusOK, res)
+	}
+
+	if res.Changed {
+		err := store.Replace(recordName(zone), cur, rrs)
+		if err == backend.ErrConflict {
+			return newError(http.StatusConflict, errConflict, "record was modified concurrently, please retry")
+		} else if err != nil {
+			log.Println("error:", err)
+			return newError(http.StatusInternalServerError, errUpdateFailed, "update failed")
+		}
+	}
+	return c.JSON(http.StatusAccepted, res)
+}
@@ -0,0 +1,295 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/baccenfutter/cion/backend"
+	my_middleware "github.com/baccenfutter/cion/middleware"
+	"github.com/labstack/echo"
+	"github.com/miekg/dns"
+)
+
+// testZone is the zone all tests operate on, below the root domain set up by
+// TestMain.
+const (
+	testZone = "example"
+	testApex = "example.cion.test."
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv("CION_ROOT_DOMAIN", "cion.test")
+	os.Exit(m.Run())
+}
+
+// newTestContext returns the context of a request authenticated for testZone
+// with headers, and the recorder of its response. The store is reset to an
+// empty memory backend and the rate limits are lifted.
+func newTestContext(headers my_middleware.CionHeaders) (echo.Context, *httptest.ResponseRecorder) {
+	store = backend.NewMemory()
+	limitUpdates = map[string]*limiter{}
+
+	e := echo.New()
+	e.HTTPErrorHandler = errorHandler(e)
+	req := httptest.NewRequest(http.MethodPost, "/zone/"+testZone, nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("zone")
+	c.SetParamValues(testZone)
+	headers.Zone = testZone
+	c.Set("cion_headers", headers)
+	return c, rec
+}
+
+// mustRR parses a record in zone file format, failing the test on errors.
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("can not parse %q: %s", s, err)
+	}
+	return rr
+}
+
+// mustRRs parses several records, see mustRR.
+func mustRRs(t *testing.T, ss ...string) []dns.RR {
+	t.Helper()
+	rrs := []dns.RR{}
+	for _, s := range ss {
+		rrs = append(rrs, mustRR(t, s))
+	}
+	return rrs
+}
+
+// assertZone fails the test unless the store holds exactly the records want,
+// including their TTLs.
+func assertZone(t *testing.T, want ...string) {
+	t.Helper()
+	got, err := store.List(testApex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRRs := mustRRs(t, want...)
+	backend.Sort(wantRRs)
+	if len(got) != len(wantRRs) {
+		t.Fatalf("zone holds %v, want %v", got, wantRRs)
+	}
+	for _, rr := range wantRRs {
+		found := false
+		for _, cur := range got {
+			if dns.IsDuplicate(cur, rr) && cur.Header().Ttl == rr.Header().Ttl {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("zone holds %v, want %v", got, wantRRs)
+		}
+	}
+}
+
+// decodeResponse decodes the record response of rec.
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder) recordResponse {
+	t.Helper()
+	res := recordResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("can not decode response %q: %s", rec.Body.String(), err)
+	}
+	return res
+}
+
+func TestUpdateRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial []string
+		rr      string
+		want    []string
+		changed bool
+	}{
+		{
+			name:    "new record gets the default ttl",
+			rr:      "www.example.cion.test. 0 IN A 192.0.2.1",
+			want:    []string{"www.example.cion.test. 180 IN A 192.0.2.1"},
+			changed: true,
+		},
+		{
+			name:    "address is replaced",
+			initial: []string{"www.example.cion.test. 180 IN A 192.0.2.1"},
+			rr:      "www.example.cion.test. 0 IN A 192.0.2.2",
+			want:    []string{"www.example.cion.test. 180 IN A 192.0.2.2"},
+			changed: true,
+		},
+		{
+			name:    "same address is unchanged",
+			initial: []string{"www.example.cion.test. 180 IN A 192.0.2.1"},
+			rr:      "www.example.cion.test. 0 IN A 192.0.2.1",
+			want:    []string{"www.example.cion.test. 180 IN A 192.0.2.1"},
+		},
+		{
+			name:    "ttl of the rrset is kept",
+			initial: []string{"www.example.cion.test. 3600 IN A 192.0.2.1"},
+			rr:      "www.example.cion.test. 0 IN A 192.0.2.2",
+			want:    []string{"www.example.cion.test. 3600 IN A 192.0.2.2"},
+			changed: true,
+		},
+		{
+			name:    "ttl change alone is a change",
+			initial: []string{"www.example.cion.test. 180 IN A 192.0.2.1"},
+			rr:      "www.example.cion.test. 600 IN A 192.0.2.1",
+			want:    []string{"www.example.cion.test. 600 IN A 192.0.2.1"},
+			changed: true,
+		},
+		{
+			name:    "mx with other preference is added",
+			initial: []string{"example.cion.test. 180 IN MX 10 mx1.example.org."},
+			rr:      "example.cion.test. 0 IN MX 20 mx2.example.org.",
+			want: []string{
+				"example.cion.test. 180 IN MX 10 mx1.example.org.",
+				"example.cion.test. 180 IN MX 20 mx2.example.org.",
+			},
+			changed: true,
+		},
+		{
+			name:    "mx with same preference is replaced",
+			initial: []string{"example.cion.test. 180 IN MX 10 mx1.example.org.", "example.cion.test. 180 IN MX 20 mx2.example.org."},
+			rr:      "example.cion.test. 0 IN MX 10 mx3.example.org.",
+			want: []string{
+				"example.cion.test. 180 IN MX 10 mx3.example.org.",
+				"example.cion.test. 180 IN MX 20 mx2.example.org.",
+			},
+			changed: true,
+		},
+		{
+			name:    "requested ttl applies to the rrset",
+			initial: []string{"example.cion.test. 180 IN MX 10 mx1.example.org."},
+			rr:      "example.cion.test. 600 IN MX 20 mx2.example.org.",
+			want: []string{
+				"example.cion.test. 600 IN MX 10 mx1.example.org.",
+				"example.cion.test. 600 IN MX 20 mx2.example.org.",
+			},
+			changed: true,
+		},
+		{
+			name:    "srv with same priority and weight is replaced",
+			initial: []string{"_sip._tcp.example.cion.test. 180 IN SRV 10 5 5060 a.example.org.", "_sip._tcp.example.cion.test. 180 IN SRV 10 6 5060 b.example.org."},
+			rr:      "_sip._tcp.example.cion.test. 0 IN SRV 10 5 5061 c.example.org.",
+			want: []string{
+				"_sip._tcp.example.cion.test. 180 IN SRV 10 5 5061 c.example.org.",
+				"_sip._tcp.example.cion.test. 180 IN SRV 10 6 5060 b.example.org.",
+			},
+			changed: true,
+		},
+		{
+			name:    "txt is added",
+			initial: []string{"example.cion.test. 180 IN TXT \"a\""},
+			rr:      "example.cion.test. 0 IN TXT \"b\"",
+			want:    []string{"example.cion.test. 180 IN TXT \"a\"", "example.cion.test. 180 IN TXT \"b\""},
+			changed: true,
+		},
+		{
+			name:    "same txt is not duplicated",
+			initial: []string{"example.cion.test. 180 IN TXT \"a\""},
+			rr:      "example.cion.test. 0 IN TXT \"a\"",
+			want:    []string{"example.cion.test. 180 IN TXT \"a\""},
+		},
+		{
+			name:    "caa flags are replaced",
+			initial: []string{"example.cion.test. 180 IN CAA 0 issue \"ca.example.org\"", "example.cion.test. 180 IN CAA 0 issue \"ca.example.net\""},
+			rr:      "example.cion.test. 0 IN CAA 128 issue \"ca.example.org\"",
+			want: []string{
+				"example.cion.test. 180 IN CAA 128 issue \"ca.example.org\"",
+				"example.cion.test. 180 IN CAA 0 issue \"ca.example.net\"",
+			},
+			changed: true,
+		},
+		{
+			name:    "sshfp of the same algorithm and type is replaced",
+			initial: []string{"host.example.cion.test. 180 IN SSHFP 4 2 " + hex32('a'), "host.example.cion.test. 180 IN SSHFP 1 2 " + hex32('b')},
+			rr:      "host.example.cion.test. 0 IN SSHFP 4 2 " + hex32('c'),
+			want: []string{
+				"host.example.cion.test. 180 IN SSHFP 4 2 " + hex32('c'),
+				"host.example.cion.test. 180 IN SSHFP 1 2 " + hex32('b'),
+			},
+			changed: true,
+		},
+		{
+			name:    "svcb alias replaces all",
+			initial: []string{"example.cion.test. 180 IN HTTPS 1 . alpn=h2", "example.cion.test. 180 IN HTTPS 2 alt.example.org. alpn=h2"},
+			rr:      "example.cion.test. 0 IN HTTPS 0 cdn.example.org.",
+			want:    []string{"example.cion.test. 180 IN HTTPS 0 cdn.example.org."},
+			changed: true,
+		},
+		{
+			name:    "svcb service replaces an alias",
+			initial: []string{"example.cion.test. 180 IN HTTPS 0 cdn.example.org."},
+			rr:      "example.cion.test. 0 IN HTTPS 1 . alpn=h2",
+			want:    []string{"example.cion.test. 180 IN HTTPS 1 . alpn=h2"},
+			changed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := newTestContext(my_middleware.CionHeaders{})
+			if err := store.Create(testApex, mustRRs(t, tt.initial...)...); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := updateRecord(c, testZone, mustRR(t, tt.rr)); err != nil {
+				t.Fatal(err)
+			}
+			assertZone(t, tt.want...)
+			if res := decodeResponse(t, rec); res.Changed != tt.changed {
+				t.Errorf("changed = %v, want %v", res.Changed, tt.changed)
+			}
+		})
+	}
+}
+
+func TestDeleteRecord(t *testing.T) {
+	c, _ := newTestContext(my_middleware.CionHeaders{})
+	if err := store.Create(testApex, mustRRs(t,
+		"example.cion.test. 600 IN TXT \"a\"",
+		"example.cion.test. 600 IN TXT \"b\"",
+	)...); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := deleteRecord(c, testZone, mustRR(t, "example.cion.test. 0 IN TXT \"a\"")); err != nil {
+		t.Fatal(err)
+	}
+	assertZone(t, "example.cion.test. 600 IN TXT \"b\"")
+
+	err := deleteRecord(c, testZone, mustRR(t, "example.cion.test. 0 IN TXT \"a\""))
+	if he, ok := err.(*echo.HTTPError); !ok || he.Code != http.StatusNotFound {
+		t.Fatalf("deleting a missing record: got %v, want 404", err)
+	}
+}
+
+func TestReplaceRecordsDryRun(t *testing.T) {
+	c, rec := newTestContext(my_middleware.CionHeaders{DryRun: true})
+	if err := store.Create(testApex, mustRR(t, "www.example.cion.test. 180 IN A 192.0.2.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := updateRecord(c, testZone, mustRR(t, "www.example.cion.test. 0 IN A 192.0.2.2")); err != nil {
+		t.Fatal(err)
+	}
+	assertZone(t, "www.example.cion.test. 180 IN A 192.0.2.1")
+
+	res := decodeResponse(t, rec)
+	if !res.Changed || !res.DryRun || res.Diff == nil || len(res.Diff.Added) != 1 || len(res.Diff.Removed) != 1 {
+		t.Fatalf("unexpected response: %+v", res)
+	}
+}
+
+// hex32 returns the hex encoding of 32 octets c.
+func hex32(c byte) string {
+	b := make([]byte, 64)
+	for i := range b {
+		b[i] = c
+	}
+	return string(b)
+}
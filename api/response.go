@@ -0,0 +1,252 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/miekg/dns"
+)
+
+// Operations reported in record responses.
+const (
+	opUpdate = "update"
+	opDelete = "delete"
+	opList   = "list"
+
+	opDeleteZone = "delete_zone"
+)
+
+// mimeZoneFile is the media type of the zone file format, see RFC 4027.
+const mimeZoneFile = "text/dns"
+
+// Machine readable error codes.
+const (
+	errBadRequest        = "bad_request"
+	errMalformedRequest  = "malformed_request"
+	errInvalidParameters = "invalid_parameters"
+	errInvalidType       = "invalid_type"
+	errMissingType       = "missing_type"
+	errUnknownAddress    = "unknown_address"
+	errUnauthorized      = "unauthorized"
+	errForbidden         = "forbidden"
+	errNotFound          = "not_found"
+	errNotAcceptable     = "not_acceptable"
+	errConflict          = "conflict"
+	errZoneTaken         = "zone_taken"
+	errRateLimited       = "rate_limited"
+	errStoreUnavailable  = "store_unavailable"
+	errUpdateFailed      = "update_failed"
+	errInternal          = "internal_error"
+)
+
+type (
+	// recordResponse is the response of all record operations.
+	recordResponse struct {
+		Operation string `json:"operation"`
+		Zone      string `json:"zone"`
+		// Changed reports whether the operation modified the zone, or would
+		// have modified it in case of a dry-run.
+		Changed bool        `json:"changed"`
+		DryRun  bool        `json:"dry_run,omitempty"`
+		Diff    *recordDiff `json:"diff,omitempty"`
+		Records []record    `json:"records"`
+	}
+
+	// recordDiff lists the records added and removed by an operation.
+	recordDiff struct {
+		Added   []record `json:"added"`
+		Removed []record `json:"removed"`
+	}
+
+	// record is a single resource record.
+	record struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+		TTL  uint32 `json:"ttl"`
+		// Data holds the rdata in presentation format, Fields the parsed
+		// rdata fields.
+		Data   string                 `json:"data"`
+		Fields map[string]interface{} `json:"fields,omitempty"`
+	}
+
+	// apiError is the body of all error responses.
+	apiError struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+)
+
+// newError returns an HTTP error carrying a machine readable code.
+func newError(status int, code, message string) *echo.HTTPError {
+	return echo.NewHTTPError(status, apiError{Code: code, Message: message})
+}
+
+// errorHandler responds to all errors with an apiError. Errors which do not
+// carry a code yet, e.g. those of the middlewares, get one derived from their
+// HTTP status.
+func errorHandler(e *echo.Echo) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		if he, ok := err.(*echo.HTTPError); ok {
+			if msg, ok := he.Message.(string); ok {
+				err = newError(he.Code, statusCode(he.Code), msg)
+			}
+		}
+		e.DefaultHTTPErrorHandler(err, c)
+	}
+}
+
+// statusCode returns the generic error code of an HTTP status.
+func statusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return errBadRequest
+	case http.StatusUnauthorized:
+		return errUnauthorized
+	case http.StatusNotFound:
+		return errNotFound
+	case http.StatusNotAcceptable:
+		return errNotAcceptable
+	case http.StatusConflict:
+		return errConflict
+	case http.StatusTooManyRequests:
+		return errRateLimited
+	}
+	if status < http.StatusInternalServerError {
+		return errBadRequest
+	}
+	return errInternal
+}
+
+// newDiff returns the difference between the records cur and rrs.
+func newDiff(cur, rrs []dns.RR) *recordDiff {
+	return &recordDiff{
+		Added:   newRecords(subtract(rrs, cur)),
+		Removed: newRecords(subtract(cur, rrs)),
+	}
+}
+
+// changed reports whether any record was added or removed.
+func (d *recordDiff) changed() bool {
+	return len(d.Added) > 0 || len(d.Removed) > 0
+}
+
+// subtract returns the records of a which are not part of b. A record whose
+// TTL differs is not part of b, so that changes of the TTL are visible.
+func subtract(a, b []dns.RR) []dns.RR {
+	rrs := []dns.RR{}
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if dns.IsDuplicate(x, y) && x.Header().Ttl == y.Header().Ttl {
+				found = true
+				break
+			}
+		}
+		if !found {
+			rrs = append(rrs, x)
+		}
+	}
+	return rrs
+}
+
+// acceptsZoneFile reports whether the client asked for the zone file format.
+func acceptsZoneFile(c echo.Context) bool {
+	for _, accept := range strings.Split(c.Request().Header.Get(echo.HeaderAccept), ",") {
+		mime := strings.TrimSpace(strings.SplitN(accept, ";", 2)[0])
+		if strings.ToLower(mime) == mimeZoneFile {
+			return true
+		}
+	}
+	return false
+}
+
+// newRecords converts resource records for a response.
+func newRecords(rrs []dns.RR) []record {
+	records := []record{}
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		records = append(records, record{
+			Name:   hdr.Name,
+			Type:   dns.TypeToString[hdr.Rrtype],
+			TTL:    hdr.Ttl,
+			Data:   rr.String()[len(hdr.String()):],
+			Fields: rdataFields(rr),
+		})
+	}
+	return records
+}
+
+// rdataFields returns the rdata fields of the record types managed by cion.
+func rdataFields(rr dns.RR) map[string]interface{} {
+	switch rr := rr.(type) {
+	case *dns.A:
+		return map[string]interface{}{
+			"address": rr.A.String(),
+		}
+	case *dns.AAAA:
+		return map[string]interface{}{
+			"address": rr.AAAA.String(),
+		}
+	case *dns.MX:
+		return map[string]interface{}{
+			"preference": rr.Preference,
+			"exchange":   rr.Mx,
+		}
+	case *dns.SRV:
+		return map[string]interface{}{
+			"priority": rr.Priority,
+			"weight":   rr.Weight,
+			"port":     rr.Port,
+			"target":   rr.Target,
+		}
+	case *dns.TXT:
+		return map[string]interface{}{
+			"value": strings.Join(rr.Txt, ""),
+		}
+	case *dns.CNAME:
+		return map[string]interface{}{
+			"target": rr.Target,
+		}
+	case *dns.NS:
+		return map[string]interface{}{
+			"target": rr.Ns,
+		}
+	case *dns.SSHFP:
+		return map[string]interface{}{
+			"algorithm":        rr.Algorithm,
+			"fingerprint_type": rr.Type,
+			"fingerprint":      rr.FingerPrint,
+		}
+	case *dns.TLSA:
+		return map[string]interface{}{
+			"usage":         rr.Usage,
+			"selector":      rr.Selector,
+			"matching_type": rr.MatchingType,
+			"certificate":   rr.Certificate,
+		}
+	case *dns.SVCB:
+		return svcbFields(rr)
+	case *dns.HTTPS:
+		return svcbFields(&rr.SVCB)
+	case *dns.CAA:
+		return map[string]interface{}{
+			"flags": rr.Flag,
+			"tag":   rr.Tag,
+			"value": rr.Value,
+		}
+	}
+	return nil
+}
+
+// svcbFields returns the rdata fields of SVCB and HTTPS records.
+func svcbFields(rr *dns.SVCB) map[string]interface{} {
+	fields := map[string]interface{}{
+		"priority": rr.Priority,
+		"target":   rr.Target,
+	}
+	if rr.Priority != 0 {
+		fields["params"] = newSvcParams(rr.Value)
+	}
+	return fields
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/baccenfutter/cion/authkey"
+	my_middleware "github.com/baccenfutter/cion/middleware"
+	"github.com/labstack/echo"
+	"github.com/miekg/dns"
+)
+
+// validScopeName matches the names and name patterns of scopes.
+var validScopeName = regexp.MustCompile(`^(@|[a-zA-Z0-9_\-.*?\[\]^]{1,253})$`)
+
+// scopeParams is the scope of a key to create, as given in the request.
+type scopeParams struct {
+	Types    []string `json:"types"`
+	Names    []string `json:"names"`
+	ReadOnly bool     `json:"read_only"`
+}
+
+// parseScope validates the requested scope. It returns nil for a scope
+// without restrictions.
+func parseScope(params *scopeParams) (*authkey.Scope, error) {
+	if params == nil {
+		return nil, nil
+	}
+
+	scope := &authkey.Scope{ReadOnly: params.ReadOnly}
+	for _, t := range params.Types {
+		rrtype, ok := dns.StringToType[strings.ToUpper(t)]
+		if !ok {
+			return nil, newError(http.StatusBadRequest, errInvalidType, fmt.Sprintf("invalid type: %s", t))
+		}
+		scope.Types = append(scope.Types, dns.TypeToString[rrtype])
+	}
+	for _, name := range params.Names {
+		if !validScopeName.MatchString(name) {
+			return nil, newError(http.StatusBadRequest, errInvalidParameters, fmt.Sprintf("invalid name: %s", name))
+		}
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, newError(http.StatusBadRequest, errInvalidParameters, fmt.Sprintf("invalid name pattern: %s", name))
+		}
+		scope.Names = append(scope.Names, strings.ToLower(name))
+	}
+
+	if scope.Full() {
+		return nil, nil
+	}
+	return scope, nil
+}
+
+// relativeName returns owner relative to zone, @ for the zone itself.
+func relativeName(zone, owner string) string {
+	owner = dns.CanonicalName(owner)
+	apex := dns.CanonicalName(recordName(zone))
+	if owner == apex {
+		return "@"
+	}
+	return strings.TrimSuffix(owner, "."+apex)
+}
+
+// inScope reports whether scope permits to read rr.
+func inScope(scope *authkey.Scope, zone string, rr dns.RR) bool {
+	return scope.Allows(dns.TypeToString[rr.Header().Rrtype], relativeName(zone, rr.Header().Name))
+}
+
+// filterScope returns those records scope permits to read.
+func filterScope(scope *authkey.Scope, zone string, rrs []dns.RR) []dns.RR {
+	if scope.Full() {
+		return rrs
+	}
+	filtered := []dns.RR{}
+	for _, rr := range rrs {
+		if inScope(scope, zone, rr) {
+			filtered = append(filtered, rr)
+		}
+	}
+	return filtered
+}
+
+// checkWriteScope returns an error unless scope permits to modify all of the
+// given records.
+func checkWriteScope(scope *authkey.Scope, zone string, rrs ...dns.RR) error {
+	if scope.Full() {
+		return nil
+	}
+	if scope.ReadOnly {
+		return newError(http.StatusForbidden, errForbidden, "key is read-only")
+	}
+	for _, rr := range rrs {
+		if !inScope(scope, zone, rr) {
+			return newError(
+				http.StatusForbidden,
+				errForbidden,
+				fmt.Sprintf(
+					"key is not permitted to modify %s records of %s",
+					dns.TypeToString[rr.Header().Rrtype],
+					relativeName(zone, rr.Header().Name),
+				),
+			)
+		}
+	}
+	return nil
+}
+
+// requireOwner returns an error unless the request was authenticated with an
+// owner key of its zone, an auth key with full access which never expires.
+func requireOwner(c echo.Context) error {
+	if err := requireAuthKey(c); err != nil {
+		return err
+	}
+	cionHeaders := c.Get("cion_headers").(my_middleware.CionHeaders)
+	info, ok := keys.Key(cionHeaders.Zone, cionHeaders.KeyID)
+	if !ok || !info.Owner() {
+		return newError(http.StatusForbidden, errForbidden, "a permanent key with full access is required")
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"encoding/base64"
+	"net"
+	"net/http"
+	"regexp"
+
+	"github.com/labstack/echo"
+	"github.com/miekg/dns"
+)
+
+type (
+	// svcbRecordParams describe an alternative endpoint of a service, see
+	// RFC 9460. They are used for both SVCB and HTTPS records. Priority 0
+	// is the AliasMode, which only refers to Target. Like TXT records, they
+	// default to the zone apex.
+	svcbRecordParams struct {
+		Name     string    `json:"name" form:"name" query:"name"`
+		Priority uint16    `json:"priority" form:"priority" query:"priority"`
+		Target   string    `json:"target" form:"target" query:"target"`
+		Params   svcParams `json:"params" form:"params" query:"params"`
+		TTL      uint32    `json:"ttl" form:"ttl" query:"ttl"`
+
+		rrtype uint16
+	}
+
+	// svcParams are the SvcParams of a ServiceMode record, named by their
+	// keys.
+	svcParams struct {
+		Mandatory []string `json:"mandatory,omitempty"`
+		ALPN      []string `json:"alpn,omitempty"`
+		Port      uint16   `json:"port,omitempty"`
+		IPv4Hint  []string `json:"ipv4hint,omitempty"`
+		ECH       string   `json:"ech,omitempty"`
+		IPv6Hint  []string `json:"ipv6hint,omitempty"`
+	}
+)
+
+// validALPN matches a protocol id of the alpn parameter. Commas and
+// backslashes are excluded, they would have to be escaped.
+var validALPN = regexp.MustCompile(`^[\x21-\x2b\x2d-\x5b\x5d-\x7e]{1,255}$`)
+
+func (svcbParams svcbRecordParams) isValid() bool {
+	if svcbParams.rrtype != dns.TypeSVCB && svcbParams.rrtype != dns.TypeHTTPS {
+		return false
+	}
+	if svcbParams.Name != "" && !validTXTName.MatchString(svcbParams.Name) {
+		return false
+	}
+	// The target "." is the owner itself, or in AliasMode, that the service
+	// is not available.
+	if svcbParams.Target == "" {
+		return false
+	}
+	if svcbParams.Target != "." && !validHostname.MatchString(svcbParams.Target) {
+		return false
+	}
+	// An alias has no parameters.
+	if svcbParams.Priority == 0 {
+		return svcbParams.Params.empty()
+	}
+	return svcbParams.Params.isValid()
+}
+
+// empty reports whether no parameter is set.
+func (p svcParams) empty() bool {
+	return len(p.values()) == 0 && len(p.Mandatory) == 0
+}
+
+// isValid reports whether all parameters which are set are valid.
+func (p svcParams) isValid() bool {
+	if p.Mandatory != nil {
+		// Mandatory keys have to be present, mandatory itself is implied.
+		present := map[string]bool{}
+		for _, kv := range p.values() {
+			present[kv.Key().String()] = true
+		}
+		seen := map[string]bool{}
+		for _, key := range p.Mandatory {
+			if !present[key] || seen[key] {
+				return false
+			}
+			seen[key] = true
+		}
+		if len(p.Mandatory) == 0 {
+			return false
+		}
+	}
+	if p.ALPN != nil {
+		if len(p.ALPN) == 0 {
+			return false
+		}
+		for _, id := range p.ALPN {
+			if !validALPN.MatchString(id) {
+				return false
+			}
+		}
+	}
+	if p.IPv4Hint != nil {
+		if len(p.IPv4Hint) == 0 {
+			return false
+		}
+		for _, addr := range p.IPv4Hint {
+			if !validIPv4.MatchString(addr) {
+				return false
+			}
+		}
+	}
+	if p.IPv6Hint != nil {
+		if len(p.IPv6Hint) == 0 {
+			return false
+		}
+		for _, addr := range p.IPv6Hint {
+			if parseIPv6(addr) == nil {
+				return false
+			}
+		}
+	}
+	if p.ECH != "" {
+		ech, err := base64.StdEncoding.DecodeString(p.ECH)
+		if err != nil || len(ech) == 0 || len(ech) > 0xffff {
+			return false
+		}
+	}
+	return true
+}
+
+// values returns the parameters other than mandatory, ordered by their key
+// as required on the wire.
+func (p svcParams) values() []dns.SVCBKeyValue {
+	values := []dns.SVCBKeyValue{}
+	if len(p.ALPN) > 0 {
+		values = append(values, &dns.SVCBAlpn{Alpn: p.ALPN})
+	}
+	if p.Port != 0 {
+		values = append(values, &dns.SVCBPort{Port: p.Port})
+	}
+	if len(p.IPv4Hint) > 0 {
+		hint := []net.IP{}
+		for _, addr := range p.IPv4Hint {
+			hint = append(hint, net.ParseIP(addr).To4())
+		}
+		values = append(values, &dns.SVCBIPv4Hint{Hint: hint})
+	}
+	if p.ECH != "" {
+		ech, _ := base64.StdEncoding.DecodeString(p.ECH)
+		values = append(values, &dns.SVCBECHConfig{ECH: ech})
+	}
+	if len(p.IPv6Hint) > 0 {
+		hint := []net.IP{}
+		for _, addr := range p.IPv6Hint {
+			hint = append(hint, parseIPv6(addr))
+		}
+		values = append(values, &dns.SVCBIPv6Hint{Hint: hint})
+	}
+	return values
+}
+
+// newSvcParams returns the parameters of a record. Parameters not managed by
+// cion are only part of its presentation format.
+func newSvcParams(values []dns.SVCBKeyValue) svcParams {
+	p := svcParams{}
+	for _, kv := range values {
+		switch kv := kv.(type) {
+		case *dns.SVCBMandatory:
+			for _, key := range kv.Code {
+				p.Mandatory = append(p.Mandatory, key.String())
+			}
+		case *dns.SVCBAlpn:
+			p.ALPN = kv.Alpn
+		case *dns.SVCBPort:
+			p.Port = kv.Port
+		case *dns.SVCBIPv4Hint:
+			for _, ip := range kv.Hint {
+				p.IPv4Hint = append(p.IPv4Hint, ip.String())
+			}
+		case *dns.SVCBECHConfig:
+			p.ECH = base64.StdEncoding.EncodeToString(kv.ECH)
+		case *dns.SVCBIPv6Hint:
+			for _, ip := range kv.Hint {
+				p.IPv6Hint = append(p.IPv6Hint, ip.String())
+			}
+		}
+	}
+	return p
+}
+
+func (svcbParams svcbRecordParams) rr(zone string) dns.RR {
+	name := recordName(zone)
+	if svcbParams.Name != "" {
+		name = recordName(zone, svcbParams.Name)
+	}
+
+	values := []dns.SVCBKeyValue{}
+	if len(svcbParams.Params.Mandatory) > 0 {
+		mandatory := &dns.SVCBMandatory{}
+		for _, kv := range svcbParams.Params.values() {
+			for _, key := range svcbParams.Params.Mandatory {
+				if kv.Key().String() == key {
+					mandatory.Code = append(mandatory.Code, kv.Key())
+				}
+			}
+		}
+		values = append(values, mandatory)
+	}
+	values = append(values, svcbParams.Params.values()...)
+
+	svcb := dns.SVCB{
+		Hdr:      ttlHeader(name, svcbParams.rrtype, svcbParams.TTL),
+		Priority: svcbParams.Priority,
+		Target:   dns.Fqdn(svcbParams.Target),
+		Value:    values,
+	}
+	if svcbParams.rrtype == dns.TypeHTTPS {
+		return &dns.HTTPS{SVCB: svcb}
+	}
+	return &svcb
+}
+
+// svcbData returns the data of an SVCB or HTTPS record.
+func svcbData(rr dns.RR) *dns.SVCB {
+	switch rr := rr.(type) {
+	case *dns.SVCB:
+		return rr
+	case *dns.HTTPS:
+		return &rr.SVCB
+	}
+	return nil
+}
+
+func getSVCBParams(c echo.Context, rrtype uint16) (*svcbRecordParams, error) {
+	svcbParams := new(svcbRecordParams)
+	if err := c.Bind(svcbParams); err != nil {
+		return nil, newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
+	}
+	svcbParams.rrtype = rrtype
+
+	if !svcbParams.isValid() {
+		return nil, newError(http.StatusBadRequest, errInvalidParameters, "request parameters not valid or missing!")
+	}
+	return svcbParams, nil
+}
+
+func createOrUpdateSVCBRecord(c echo.Context, zone string, rrtype uint16) error {
+	svcbParams, err := getSVCBParams(c, rrtype)
+	if err != nil {
+		return err
+	}
+
+	return updateRecord(c, zone, svcbParams.rr(zone))
+}
+
+func deleteSVCBRecord(c echo.Context, zone string, rrtype uint16) error {
+	svcbParams, err := getSVCBParams(c, rrtype)
+	if err != nil {
+		return err
+	}
+	return deleteRecord(c, zone, svcbParams.rr(zone))
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSVCBRecordParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params svcbRecordParams
+		valid  bool
+	}{
+		{"alias", svcbRecordParams{Target: "cdn.example.org"}, true},
+		{"service unavailable", svcbRecordParams{Target: "."}, true},
+		{"service", svcbRecordParams{Priority: 1, Target: ".", Params: svcParams{ALPN: []string{"h2", "h3"}, Port: 8443}}, true},
+		{"hints", svcbRecordParams{Priority: 1, Target: ".", Params: svcParams{IPv4Hint: []string{"192.0.2.1"}, IPv6Hint: []string{"2001:db8::1"}}}, true},
+		{"ech", svcbRecordParams{Priority: 1, Target: ".", Params: svcParams{ECH: "AEX+DQBB"}}, true},
+		{"mandatory", svcbRecordParams{Priority: 1, Target: ".", Params: svcParams{Mandatory: []string{"alpn"}, ALPN: []string{"h2"}}}, true},
+		{"missing target", svcbRecordParams{Priority: 1}, false},
+		{"alias with params", svcbRecordParams{Target: "cdn.example.org", Params: svcParams{Port: 443}}, false},
+		{"empty alpn", svcbRecordParams{Priority: 1, Target: ".", Params: svcParams{ALPN: []string{}}}, false},
+		{"alpn with comma", svcbRecordParams{Priority: 1, Target: ".", Params: svcParams{ALPN: []string{"h2,h3"}}}, false},
+		{"ipv6 as ipv4hint", svcbRecordParams{Priority: 1, Target: ".", Params: svcParams{IPv4Hint: []string{"2001:db8::1"}}}, false},
+		{"ipv4 as ipv6hint", svcbRecordParams{Priority: 1, Target: ".", Params: svcParams{IPv6Hint: []string{"192.0.2.1"}}}, false},
+		{"malformed ech", svcbRecordParams{Priority: 1, Target: ".", Params: svcParams{ECH: "not base64!"}}, false},
+		{"mandatory missing key", svcbRecordParams{Priority: 1, Target: ".", Params: svcParams{Mandatory: []string{"port"}, ALPN: []string{"h2"}}}, false},
+		{"mandatory duplicate key", svcbRecordParams{Priority: 1, Target: ".", Params: svcParams{Mandatory: []string{"alpn", "alpn"}, ALPN: []string{"h2"}}}, false},
+		{"mandatory empty", svcbRecordParams{Priority: 1, Target: ".", Params: svcParams{Mandatory: []string{}}}, false},
+	}
+	for _, rrtype := range []uint16{dns.TypeSVCB, dns.TypeHTTPS} {
+		for _, tt := range tests {
+			tt.params.rrtype = rrtype
+			if got := tt.params.isValid(); got != tt.valid {
+				t.Errorf("%s %s: isValid = %v, want %v", dns.TypeToString[rrtype], tt.name, got, tt.valid)
+			}
+		}
+	}
+
+	// Only SVCB and HTTPS records are built from the params.
+	if (svcbRecordParams{Target: "."}).isValid() {
+		t.Error("params without type are valid")
+	}
+}
+
+func TestSVCBRecordParamsRR(t *testing.T) {
+	params := svcbRecordParams{
+		Priority: 1,
+		Target:   ".",
+		Params: svcParams{
+			Mandatory: []string{"port"},
+			IPv6Hint:  []string{"2001:db8::1"},
+			Port:      8443,
+			ALPN:      []string{"h2"},
+		},
+		rrtype: dns.TypeHTTPS,
+	}
+	// Without a requested TTL, it is left to applyTTL.
+	rr := params.rr(testZone)
+	want := "example.cion.test.\t0\tIN\tHTTPS\t1 . mandatory=\"port\" alpn=\"h2\" port=\"8443\" ipv6hint=\"2001:db8::1\""
+	if rr.String() != want {
+		t.Errorf("rr = %q, want %q", rr.String(), want)
+	}
+
+	// The params are read back as they were given.
+	got := newSvcParams(svcbData(rr).Value)
+	if len(got.Mandatory) != 1 || got.Port != 8443 || len(got.ALPN) != 1 || got.IPv6Hint[0] != "2001:db8::1" {
+		t.Errorf("newSvcParams = %+v", got)
+	}
+}
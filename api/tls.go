@@ -0,0 +1,106 @@
+package api
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/baccenfutter/cion/config"
+	"github.com/labstack/echo"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serve runs the HTTP server on the configured address. If TLS is
+// configured, the HTTPS server is run as well and plain HTTP requests are
+// redirected to it.
+func serve(e *echo.Echo) error {
+	cfg := config.Config()
+
+	var handler http.Handler = e
+	if cfg.HTTPRedirect {
+		handler = redirectHandler(cfg.TLSListen)
+	}
+
+	var tlsConfig *tls.Config
+	switch {
+	case cfg.TLSCertFile != "" || cfg.TLSKeyFile != "":
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	case cfg.AutoCert && cfg.AutoCertDNS:
+		m, err := newDNSCertManager(cfg)
+		if err != nil {
+			return err
+		}
+		go m.Run()
+		tlsConfig = &tls.Config{GetCertificate: m.GetCertificate}
+
+	case cfg.AutoCert:
+		if err := os.MkdirAll(cfg.CertDir, os.FileMode(0700)); err != nil {
+			return err
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cfg.CertDir),
+			HostPolicy: autocert.HostWhitelist(append([]string{cfg.RootDomain}, cfg.TLSHosts...)...),
+			Client:     &acme.Client{DirectoryURL: cfg.ACMEDirectory},
+			Email:      cfg.ACMEEmail,
+		}
+		// HTTP-01 challenges are answered on the plain HTTP listener.
+		handler = m.HTTPHandler(handler)
+		tlsConfig = m.TLSConfig()
+
+	default:
+		return e.Start(cfg.Listen)
+	}
+
+	go func() {
+		log.Fatal(http.ListenAndServe(cfg.Listen, handler))
+	}()
+
+	s := e.TLSServer
+	s.Addr = cfg.TLSListen
+	s.TLSConfig = tlsConfig
+	if !e.DisableHTTP2 && !hasProto(s.TLSConfig.NextProtos, "h2") {
+		s.TLSConfig.NextProtos = append(s.TLSConfig.NextProtos, "h2")
+	}
+	return e.StartServer(s)
+}
+
+// redirectHandler redirects all requests to the HTTPS server listening on
+// addr.
+func redirectHandler(addr string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if _, port, err := net.SplitHostPort(addr); err == nil && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		// Keep the method of API requests, which the older status codes
+		// do not guarantee.
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
+
+// hasProto reports whether protos contains proto.
+func hasProto(protos []string, proto string) bool {
+	for _, p := range protos {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
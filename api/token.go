@@ -0,0 +1,106 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/baccenfutter/cion/authkey"
+	my_middleware "github.com/baccenfutter/cion/middleware"
+	"github.com/labstack/echo"
+)
+
+type (
+	// tokenParams is the body of token requests. ExpiresIn is a duration like
+	// "15m", it defaults to the maximum lifetime of tokens.
+	tokenParams struct {
+		Scope     *scopeParams `json:"scope"`
+		ExpiresIn string       `json:"expires_in" form:"expires_in" query:"expires_in"`
+	}
+
+	// tokenResponse holds an issued access token.
+	tokenResponse struct {
+		Zone      string         `json:"zone"`
+		Token     string         `json:"token"`
+		TokenType string         `json:"token_type"`
+		Expires   time.Time      `json:"expires"`
+		Scope     *authkey.Scope `json:"scope,omitempty"`
+	}
+)
+
+// createToken is the echo handler for exchanging an auth key for a short-lived
+// access token, which is accepted as Authorization: Bearer in place of the
+// key. The token has the scope of the key, a key with full access may
+// restrict it further. Revoking the key revokes all of its tokens.
+// It returns
+//   - http202 and the token
+//   - http400 if the scope or the expiry are invalid
+//   - http403 if the request was authenticated with a token
+func createToken(c echo.Context) error {
+	cionHeaders := c.Get("cion_headers").(my_middleware.CionHeaders)
+
+	if !allowUpdate(cionHeaders.Zone, cionHeaders.KeyID) {
+		log.Printf("warning: client reached update limit: %s\n", clientAddr(c.Request()))
+		return newError(http.StatusTooManyRequests, errRateLimited, "one update per second with max burst of ten, please")
+	}
+	if err := requireAuthKey(c); err != nil {
+		return err
+	}
+
+	// All parameters are optional, so is the body.
+	params := new(tokenParams)
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(params); err != nil {
+			return newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
+		}
+	}
+	var ttl time.Duration
+	if params.ExpiresIn != "" {
+		var err error
+		ttl, err = time.ParseDuration(params.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			return newError(http.StatusBadRequest, errInvalidParameters, "invalid expiry")
+		}
+	}
+
+	scope := cionHeaders.Scope
+	if params.Scope != nil {
+		if !scope.Full() {
+			return newError(http.StatusForbidden, errForbidden, "the scope of a restricted key can not be changed")
+		}
+		var err error
+		scope, err = parseScope(params.Scope)
+		if err != nil {
+			return err
+		}
+	}
+
+	key, ok := keys.Key(cionHeaders.Zone, cionHeaders.KeyID)
+	if !ok {
+		return newError(http.StatusUnauthorized, errUnauthorized, "key revoked")
+	}
+	token, expires, err := tokens.Issue(cionHeaders.Zone, key, scope, ttl)
+	if err != nil {
+		log.Println("error: can not issue token:", err)
+		return newError(http.StatusInternalServerError, errInternal, "can not issue token")
+	}
+
+	return c.JSON(http.StatusAccepted, tokenResponse{
+		Zone:      cionHeaders.Zone,
+		Token:     token,
+		TokenType: "Bearer",
+		Expires:   expires,
+		Scope:     scope,
+	})
+}
+
+// requireAuthKey returns an error if the request was authenticated with an
+// access token, so that tokens can not be used to issue longer-lived
+// credentials.
+func requireAuthKey(c echo.Context) error {
+	cionHeaders := c.Get("cion_headers").(my_middleware.CionHeaders)
+	if cionHeaders.Token {
+		return newError(http.StatusForbidden, errForbidden, "an auth key is required")
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/baccenfutter/cion/config"
+	"github.com/baccenfutter/cion/nsupdate"
+)
+
+// zoneKeysMutex serializes the regeneration of the zone keys file.
+var zoneKeysMutex sync.Mutex
+
+// createZoneKey generates a TSIG key for zone, persists it next to the auth
+// key and makes it known to the nameserver.
+//
+// The key is named like the zone itself. The root zone grants every key
+// "selfwild" rights for the record types cion manages, except NS, so the key
+// is only permitted to update records below its zone. The zone apex and
+// delegations remain subject to the validation of the API.
+func createZoneKey(zone string) (*nsupdate.Key, error) {
+	key, err := nsupdate.GenerateKey(recordName(zone))
+	if err != nil {
+		return nil, err
+	}
+
+	filePath := filepath.Join(CionKeyDir, zone+".tsig")
+	err = ioutil.WriteFile(filePath, []byte(key.String()), os.FileMode(0600))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeZoneKeys(); err != nil {
+		os.Remove(filePath)
+		return nil, err
+	}
+	return key, nil
+}
+
+// deleteZoneKey revokes the TSIG key of zone, if it has one.
+func deleteZoneKey(zone string) error {
+	err := os.Remove(filepath.Join(CionKeyDir, zone+".tsig"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return writeZoneKeys()
+}
+
+// writeZoneKeys collects all per-zone TSIG keys into the zone keys file and
+// tells the nameserver to reload its configuration.
+func writeZoneKeys() error {
+	zoneKeysMutex.Lock()
+	defer zoneKeysMutex.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(CionKeyDir, "*.tsig"))
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.NewBufferString("# Generated by cion, do not edit.\n")
+	for _, path := range paths {
+		key, err := nsupdate.LoadKey(path)
+		if err != nil {
+			log.Printf("warning: skipping TSIG key %s: %s\n", path, err)
+			continue
+		}
+		buf.WriteString(key.String())
+	}
+
+	// Replace the file atomically, named must never see a partial file.
+	filePath := config.Config().ZoneKeysFile
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), ".zonekeys")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+	if err := os.Chmod(tmp.Name(), os.FileMode(0640)); err != nil {
+		return err
+	}
+	if group, err := user.LookupGroup("named"); err == nil {
+		gid, _ := strconv.Atoi(group.Gid)
+		os.Chown(tmp.Name(), -1, gid)
+	}
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return err
+	}
+
+	out, err := exec.Command("rndc", "reconfig").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("rndc reconfig: %s: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,474 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	my_middleware "github.com/baccenfutter/cion/middleware"
+	"github.com/labstack/echo"
+	"github.com/miekg/dns"
+)
+
+// opReplace is reported for the replacement of an entire RRset.
+const opReplace = "replace"
+
+// managedTypes are the record types which can be managed via the API. All
+// others, e.g. the SOA record, are maintained by the nameserver or cion.
+var managedTypes = map[uint16]bool{
+	dns.TypeA:     true,
+	dns.TypeAAAA:  true,
+	dns.TypeMX:    true,
+	dns.TypeSRV:   true,
+	dns.TypeTXT:   true,
+	dns.TypeCNAME: true,
+	dns.TypeCAA:   true,
+	dns.TypeNS:    true,
+	dns.TypeSSHFP: true,
+	dns.TypeTLSA:  true,
+	dns.TypeSVCB:  true,
+	dns.TypeHTTPS: true,
+}
+
+type (
+	// recordParams is implemented by the parameters of all record types.
+	recordParams interface {
+		isValid() bool
+		rr(zone string) dns.RR
+	}
+
+	// v2Record holds the rdata fields of a record, as they are listed by
+	// rdataFields.
+	v2Record struct {
+		Address    string `json:"address"`
+		Preference uint16 `json:"preference"`
+		Exchange   string `json:"exchange"`
+		Priority   uint16 `json:"priority"`
+		Weight     uint16 `json:"weight"`
+		Port       uint16 `json:"port"`
+		Target     string `json:"target"`
+		Value      string `json:"value"`
+		Flags      uint8  `json:"flags"`
+		Tag        string `json:"tag"`
+		// Addresses are the glue of a nameserver within the zone.
+		Addresses       []string  `json:"addresses"`
+		Algorithm       uint8     `json:"algorithm"`
+		FingerprintType uint8     `json:"fingerprint_type"`
+		Fingerprint     string    `json:"fingerprint"`
+		Usage           uint8     `json:"usage"`
+		Selector        uint8     `json:"selector"`
+		MatchingType    uint8     `json:"matching_type"`
+		Certificate     string    `json:"certificate"`
+		Params          svcParams `json:"params"`
+		// TTL is optional, without it the TTL of the RRset is kept.
+		TTL uint32 `json:"ttl"`
+	}
+
+	// v2RRset is the body of PUT requests, the new content of an RRset.
+	v2RRset struct {
+		Records []v2Record `json:"records"`
+	}
+
+	// v2Patch is the body of PATCH requests. The records in Remove are
+	// removed from the RRset, those in Add are added like by a v1 update.
+	v2Patch struct {
+		Add    []v2Record `json:"add"`
+		Remove []v2Record `json:"remove"`
+	}
+)
+
+// v2Params converts v2 record fields into the parameters of the given record
+// type. The name is relative to the zone, empty for the zone itself.
+func v2Params(zone string, rrtype uint16, name string, r v2Record) (recordParams, error) {
+	switch rrtype {
+	case dns.TypeA:
+		return aRecordParams{Name: name, Addr: r.Address}, nil
+	case dns.TypeAAAA:
+		return aaaaRecordParams{Name: name, Addr: r.Address}, nil
+	case dns.TypeMX:
+		if name != "" {
+			return nil, newError(http.StatusBadRequest, errInvalidParameters, "MX records can only be created at @")
+		}
+		return mxRecordParams{Pref: strconv.Itoa(int(r.Preference)), Name: r.Exchange}, nil
+	case dns.TypeSRV:
+		service, proto, host, ok := splitServiceName(name)
+		if !ok || host != "" {
+			return nil, newError(http.StatusBadRequest, errInvalidParameters, "SRV records have to be named _service._proto")
+		}
+		return srvRecordParams{
+			Srv:    service,
+			Proto:  proto,
+			Prio:   r.Priority,
+			Weight: r.Weight,
+			Port:   r.Port,
+			Name:   r.Target,
+		}, nil
+	case dns.TypeTXT:
+		return txtRecordParams{Name: name, Value: r.Value}, nil
+	case dns.TypeCNAME:
+		// The target is within the zone, it may be given fully qualified.
+		dest := strings.TrimSuffix(r.Target, ".")
+		suffix := "." + strings.TrimSuffix(recordName(zone), ".")
+		if strings.HasSuffix(strings.ToLower(dest), strings.ToLower(suffix)) {
+			dest = dest[:len(dest)-len(suffix)]
+		}
+		return cnameRecordParams{Name: name, Dest: dest}, nil
+	case dns.TypeCAA:
+		return caaRecordParams{Name: name, Flags: r.Flags, Tag: r.Tag, Value: r.Value}, nil
+	case dns.TypeNS:
+		if name == "" {
+			return nil, newError(http.StatusBadRequest, errInvalidParameters, "the zone itself can not be delegated")
+		}
+		return nsRecordParams{Name: name, Dest: r.Target, Addrs: r.Addresses}, nil
+	case dns.TypeSSHFP:
+		return sshfpRecordParams{
+			Name:        name,
+			Algorithm:   r.Algorithm,
+			Type:        r.FingerprintType,
+			Fingerprint: r.Fingerprint,
+		}, nil
+	case dns.TypeTLSA:
+		service, proto, host, ok := splitServiceName(name)
+		port, err := strconv.ParseUint(service, 10, 16)
+		if !ok || err != nil {
+			return nil, newError(http.StatusBadRequest, errInvalidParameters, "TLSA records have to be named _port._proto")
+		}
+		return tlsaRecordParams{
+			Port:         uint16(port),
+			Proto:        proto,
+			Name:         host,
+			Usage:        r.Usage,
+			Selector:     r.Selector,
+			MatchingType: r.MatchingType,
+			Certificate:  r.Certificate,
+		}, nil
+	case dns.TypeSVCB, dns.TypeHTTPS:
+		return svcbRecordParams{
+			Name:     name,
+			Priority: r.Priority,
+			Target:   r.Target,
+			Params:   r.Params,
+			rrtype:   rrtype,
+		}, nil
+	}
+	return nil, newError(
+		http.StatusBadRequest,
+		errInvalidType,
+		fmt.Sprintf("invalid type: %s", dns.TypeToString[rrtype]),
+	)
+}
+
+// splitServiceName splits a name of the form _service._proto, optionally
+// followed by the host the service belongs to.
+func splitServiceName(name string) (service, proto, host string, ok bool) {
+	labels := strings.SplitN(name, ".", 3)
+	if len(labels) < 2 || !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") {
+		return "", "", "", false
+	}
+	if len(labels) == 3 {
+		host = labels[2]
+	}
+	return labels[0][1:], labels[1][1:], host, true
+}
+
+// v2RRsetParams applies the rate limit and parses the type and name of the
+// RRset addressed by the request path, which has to be managed via the API.
+// They are returned together with the fully qualified owner name of the
+// RRset.
+func v2RRsetParams(c echo.Context) (zone string, rrtype uint16, name, owner string, err error) {
+	cionHeaders := c.Get("cion_headers").(my_middleware.CionHeaders)
+	zone = cionHeaders.Zone
+
+	if !allowUpdate(cionHeaders.Zone, cionHeaders.KeyID) {
+		log.Printf("warning: client reached request limit: %s\n", clientAddr(c.Request()))
+		return "", 0, "", "", newError(http.StatusTooManyRequests, errRateLimited, "one request per second with max burst of ten, please")
+	}
+
+	rrtype, name, owner, err = parseRRset(zone, c.Param("type"), c.Param("name"))
+	if err != nil {
+		return "", 0, "", "", err
+	}
+	if err := checkManaged(rrtype, name); err != nil {
+		return "", 0, "", "", err
+	}
+	return zone, rrtype, name, owner, nil
+}
+
+// checkManaged returns an error unless the RRset of the given type and name,
+// relative to the zone, can be managed via the API. The nameservers of the
+// zone itself are always those of cion.
+func checkManaged(rrtype uint16, name string) error {
+	if !managedTypes[rrtype] {
+		return newError(
+			http.StatusBadRequest,
+			errInvalidType,
+			fmt.Sprintf("invalid type: %s", dns.TypeToString[rrtype]),
+		)
+	}
+	if rrtype == dns.TypeNS && name == "" {
+		return newError(http.StatusBadRequest, errInvalidParameters, "the zone itself can not be delegated")
+	}
+	return nil
+}
+
+// parseRRset parses the type and the name of an RRset, relative to the zone
+// or @ for the zone itself. It returns the type, the relative name, empty
+// for the zone itself, and the fully qualified owner name.
+func parseRRset(zone, t, name string) (rrtype uint16, relative, owner string, err error) {
+	rrtype, ok := dns.StringToType[strings.ToUpper(t)]
+	if !ok {
+		return 0, "", "", newError(
+			http.StatusBadRequest,
+			errInvalidType,
+			fmt.Sprintf("invalid type: %s", t),
+		)
+	}
+
+	if name == "@" {
+		return rrtype, "", recordName(zone), nil
+	}
+	if !validTXTName.MatchString(name) {
+		return 0, "", "", newError(http.StatusBadRequest, errInvalidParameters, "invalid name")
+	}
+	return rrtype, name, recordName(zone, name), nil
+}
+
+// v2RR validates the record fields and returns the record for the RRset.
+func v2RR(zone string, rrtype uint16, name, owner string, r v2Record) (dns.RR, error) {
+	params, err := v2Params(zone, rrtype, name, r)
+	if err != nil {
+		return nil, err
+	}
+	if !params.isValid() {
+		return nil, newError(http.StatusBadRequest, errInvalidParameters, "request parameters not valid or missing!")
+	}
+	rr := params.rr(zone)
+	if !strings.EqualFold(rr.Header().Name, owner) {
+		return nil, newError(http.StatusBadRequest, errInvalidParameters, "invalid name")
+	}
+	rr.Header().Ttl = clampTTL(r.TTL)
+	return rr, nil
+}
+
+// v2RRs validates the fields of several records of the same RRset.
+func v2RRs(zone string, rrtype uint16, name, owner string, records []v2Record) ([]dns.RR, error) {
+	rrs := []dns.RR{}
+	for _, r := range records {
+		rr, err := v2RR(zone, rrtype, name, owner, r)
+		if err != nil {
+			return nil, err
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
+}
+
+// v2Glue returns the glue given with the records of an NS RRset.
+func v2Glue(rrtype uint16, records []v2Record) []dns.RR {
+	glue := []dns.RR{}
+	if rrtype != dns.TypeNS {
+		return glue
+	}
+	for _, r := range records {
+		glue = append(glue, nsRecordParams{Dest: r.Target, Addrs: r.Addresses, TTL: r.TTL}.glue()...)
+	}
+	return glue
+}
+
+// exclusive reports whether none of the records is a duplicate of another or
+// would supersede another.
+func exclusive(rrs []dns.RR) bool {
+	for i, rr := range rrs {
+		for _, other := range rrs[:i] {
+			if dns.IsDuplicate(other, rr) || supersedes(rr)(other) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// addRecords adds the records to the RRset rrs like a v1 update, replacing
+// the records superseded by them.
+func addRecords(rrs, add []dns.RR) []dns.RR {
+	for _, rr := range add {
+		replaced := supersedes(rr)
+		next := []dns.RR{rr}
+		for _, old := range rrs {
+			if !replaced(old) && !dns.IsDuplicate(old, rr) {
+				next = append(next, old)
+			}
+		}
+		rrs = next
+	}
+	return rrs
+}
+
+// removeRecords removes the records from the RRset rrs. It reports false if
+// any of them is not part of the RRset.
+func removeRecords(rrs, remove []dns.RR) ([]dns.RR, bool) {
+	for _, rr := range remove {
+		remaining := []dns.RR{}
+		for _, old := range rrs {
+			if !dns.IsDuplicate(old, rr) {
+				remaining = append(remaining, old)
+			}
+		}
+		if len(remaining) == len(rrs) {
+			return nil, false
+		}
+		rrs = remaining
+	}
+	return rrs, true
+}
+
+// lookupRRset returns the RRset with the given owner name and type.
+func lookupRRset(zone, owner string, rrtype uint16) ([]dns.RR, error) {
+	cur, err := store.Lookup(recordName(zone), owner, rrtype)
+	if err != nil {
+		log.Println("error:", err)
+		return nil, newError(http.StatusInternalServerError, errStoreUnavailable, "record store not available")
+	}
+	return cur, nil
+}
+
+// getRRset is the echo handler for reading a single RRset.
+// It returns
+//   - http200 and the records of the RRset
+//   - http403 if the RRset is not within the scope of the key
+//   - http404 if the RRset does not exist
+func getRRset(c echo.Context) error {
+	zone, rrtype, _, owner, err := v2RRsetParams(c)
+	if err != nil {
+		return err
+	}
+
+	cionHeaders := c.Get("cion_headers").(my_middleware.CionHeaders)
+	if !cionHeaders.Scope.Allows(dns.TypeToString[rrtype], relativeName(zone, owner)) {
+		return newError(http.StatusForbidden, errForbidden, "key is not permitted to read this record")
+	}
+
+	cur, err := lookupRRset(zone, owner, rrtype)
+	if err != nil {
+		return err
+	}
+	if len(cur) == 0 {
+		return newError(http.StatusNotFound, errNotFound, "no such record")
+	}
+
+	return c.JSON(http.StatusOK, recordResponse{
+		Operation: opList,
+		Zone:      zone,
+		Records:   newRecords(cur),
+	})
+}
+
+// putRRset is the echo handler for replacing an entire RRset.
+// It returns
+//   - http202 and the records of the RRset
+//   - http400 if the records are invalid or exclude each other
+//   - http409 if the RRset was modified concurrently
+func putRRset(c echo.Context) error {
+	zone, rrtype, name, owner, err := v2RRsetParams(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(v2RRset)
+	if err := c.Bind(body); err != nil {
+		return newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
+	}
+	if len(body.Records) == 0 {
+		return newError(http.StatusBadRequest, errInvalidParameters, "no records given, use DELETE to remove the RRset")
+	}
+
+	rrs, err := v2RRs(zone, rrtype, name, owner, body.Records)
+	if err != nil {
+		return err
+	}
+	if !exclusive(rrs) {
+		return newError(http.StatusBadRequest, errInvalidParameters, "records exclude each other")
+	}
+
+	cur, err := lookupRRset(zone, owner, rrtype)
+	if err != nil {
+		return err
+	}
+	cur, rrs, err = delegate(zone, cur, rrs, v2Glue(rrtype, body.Records))
+	if err != nil {
+		return err
+	}
+	return replaceRecords(c, opReplace, zone, cur, rrs)
+}
+
+// patchRRset is the echo handler for adding records to and removing records
+// from an RRset.
+// It returns
+//   - http202 and the records of the RRset
+//   - http400 if the records are invalid
+//   - http404 if a record to remove does not exist
+//   - http409 if the RRset was modified concurrently
+func patchRRset(c echo.Context) error {
+	zone, rrtype, name, owner, err := v2RRsetParams(c)
+	if err != nil {
+		return err
+	}
+
+	body := new(v2Patch)
+	if err := c.Bind(body); err != nil {
+		return newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
+	}
+
+	remove, err := v2RRs(zone, rrtype, name, owner, body.Remove)
+	if err != nil {
+		return err
+	}
+	add, err := v2RRs(zone, rrtype, name, owner, body.Add)
+	if err != nil {
+		return err
+	}
+
+	cur, err := lookupRRset(zone, owner, rrtype)
+	if err != nil {
+		return err
+	}
+
+	rrs, ok := removeRecords(cur, remove)
+	if !ok {
+		return newError(http.StatusNotFound, errNotFound, "no such record")
+	}
+	rrs = addRecords(rrs, add)
+
+	cur, rrs, err = delegate(zone, cur, rrs, v2Glue(rrtype, body.Add))
+	if err != nil {
+		return err
+	}
+	return replaceRecords(c, opUpdate, zone, cur, rrs)
+}
+
+// deleteRRset is the echo handler for removing an entire RRset.
+// It returns
+//   - http202 and the empty RRset
+//   - http404 if the RRset does not exist
+//   - http409 if the RRset was modified concurrently
+func deleteRRset(c echo.Context) error {
+	zone, rrtype, _, owner, err := v2RRsetParams(c)
+	if err != nil {
+		return err
+	}
+
+	cur, err := lookupRRset(zone, owner, rrtype)
+	if err != nil {
+		return err
+	}
+	if len(cur) == 0 {
+		return newError(http.StatusNotFound, errNotFound, "no such record")
+	}
+	cur, rrs, err := delegate(zone, cur, []dns.RR{}, nil)
+	if err != nil {
+		return err
+	}
+	return replaceRecords(c, opDelete, zone, cur, rrs)
+}
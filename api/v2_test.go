@@ -0,0 +1,83 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	my_middleware "github.com/baccenfutter/cion/middleware"
+	"github.com/labstack/echo"
+)
+
+// newRRsetContext returns the context of a v2 request for the RRset of the
+// given type and name with body, see newTestContext.
+func newRRsetContext(method, rrtype, name, body string) echo.Context {
+	c, _ := newTestContext(my_middleware.CionHeaders{KeyID: "test"})
+	req := c.Request()
+	req.Method = method
+	req.Body = ioutil.NopCloser(strings.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c.SetParamNames("zone", "type", "name")
+	c.SetParamValues(testZone, rrtype, name)
+	return c
+}
+
+// assertStatus fails the test unless err is an HTTP error with status code.
+func assertStatus(t *testing.T, err error, code int) {
+	t.Helper()
+	he, ok := err.(*echo.HTTPError)
+	if !ok || he.Code != code {
+		t.Fatalf("got %v, want status %d", err, code)
+	}
+}
+
+func TestDeleteRRsetManagedTypes(t *testing.T) {
+	apex := []string{
+		"example.cion.test. 180 IN SOA ns1.cion.test. hostmaster.cion.test. 1 3600 600 86400 180",
+		"example.cion.test. 180 IN NS ns1.cion.test.",
+		"sub.example.cion.test. 180 IN NS ns.example.org.",
+		"www.example.cion.test. 180 IN A 192.0.2.1",
+	}
+	tests := []struct {
+		rrtype string
+		name   string
+		code   int
+		want   []string
+	}{
+		{"SOA", "@", http.StatusBadRequest, apex},
+		{"NS", "@", http.StatusBadRequest, apex},
+		{"ns", "@", http.StatusBadRequest, apex},
+		{"DNSKEY", "@", http.StatusBadRequest, apex},
+		{"NS", "sub", http.StatusAccepted, []string{apex[0], apex[1], apex[3]}},
+		{"A", "www", http.StatusAccepted, apex[:3]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rrtype+"/"+tt.name, func(t *testing.T) {
+			c := newRRsetContext(http.MethodDelete, tt.rrtype, tt.name, "")
+			if err := store.Create(testApex, mustRRs(t, apex...)...); err != nil {
+				t.Fatal(err)
+			}
+
+			err := deleteRRset(c)
+			if tt.code == http.StatusAccepted {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				assertStatus(t, err, tt.code)
+			}
+			assertZone(t, tt.want...)
+		})
+	}
+}
+
+func TestPutRRsetManagedTypes(t *testing.T) {
+	c := newRRsetContext(http.MethodPut, "SOA", "@",
+		`{"records":[{"value":"ns1.cion.test. hostmaster.cion.test. 2 3600 600 86400 180"}]}`)
+	assertStatus(t, putRRset(c), http.StatusBadRequest)
+
+	c = newRRsetContext(http.MethodGet, "NS", "@", "")
+	assertStatus(t, getRRset(c), http.StatusBadRequest)
+}
@@ -4,38 +4,75 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
-	"os"
-	"os/exec"
-	"path/filepath"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/baccenfutter/cion/backend"
+	"github.com/baccenfutter/cion/config"
 	my_middleware "github.com/baccenfutter/cion/middleware"
+	"github.com/baccenfutter/cion/nsupdate"
 	"github.com/labstack/echo"
+	"github.com/miekg/dns"
 	"github.com/satori/go.uuid"
 	"golang.org/x/time/rate"
 )
 
+// caaFlagCritical is the issuer critical flag of CAA records.
+const caaFlagCritical = 128
+
+// tlsaMaxData limits the size of full certificates and public keys in TLSA
+// records, in octets.
+const tlsaMaxData = 4096
+
+var (
+	// sshfpAlgorithms are the SSHFP key algorithms: RSA, DSA, ECDSA, Ed25519
+	// and Ed448.
+	sshfpAlgorithms = map[uint8]bool{1: true, 2: true, 3: true, 4: true, 6: true}
+
+	// sshfpTypes map the SSHFP fingerprint types SHA-1 and SHA-256 to the
+	// lengths of their fingerprints in octets.
+	sshfpTypes = map[uint8]int{1: 20, 2: 32}
+
+	// tlsaMatchingTypes map the TLSA matching types SHA-256 and SHA-512 to
+	// the lengths of their hashes in octets. The full data, type 0, is of
+	// variable length.
+	tlsaMatchingTypes = map[uint8]int{0: 0, 1: 32, 2: 64}
+)
+
 type (
 	// zone is a container for zone registration requests/responses.
 	zone struct {
 		Zone    string `json:"zone"`
 		AuthKey string `json:"auth_key"`
+		// TSIG requests a key for sending RFC 2136 updates directly to the
+		// nameserver, it is returned as TSIGKey.
+		TSIG    bool          `json:"tsig,omitempty"`
+		TSIGKey *nsupdate.Key `json:"tsig_key,omitempty"`
 	}
 
 	aRecordParams struct {
 		Name string `json:"name" form:"name" query:"name"`
 		Addr string `json:"address" form:"address" query:"address"`
+		TTL  uint32 `json:"ttl" form:"ttl" query:"ttl"`
+	}
+
+	aaaaRecordParams struct {
+		Name string `json:"name" form:"name" query:"name"`
+		Addr string `json:"address" form:"address" query:"address"`
+		TTL  uint32 `json:"ttl" form:"ttl" query:"ttl"`
 	}
 
 	mxRecordParams struct {
 		Pref string `json:"pref" form:"pref" query:"pref"`
 		Name string `json:"name" form:"name" query:"name"`
+		TTL  uint32 `json:"ttl" form:"ttl" query:"ttl"`
 	}
 
 	// srvRecordParams is a container for the record update requests/responses.
@@ -46,15 +83,60 @@ type (
 		Weight uint16 `json:"weight" form:"weight" query:"weight"`
 		Port   uint16 `json:"port" form:"port" query:"port"`
 		Name   string `json:"name" form:"name" query:"name"`
+		TTL    uint32 `json:"ttl" form:"ttl" query:"ttl"`
 	}
 
 	txtRecordParams struct {
+		Name  string `json:"name" form:"name" query:"name"`
 		Value string `json:"value" form:"value" query:"value" required:"false"`
+		TTL   uint32 `json:"ttl" form:"ttl" query:"ttl"`
 	}
 
 	cnameRecordParams struct {
 		Name string `json:"name" form:"name" query:"name"`
 		Dest string `json:"dest" form:"dest" query:"dest"`
+		TTL  uint32 `json:"ttl" form:"ttl" query:"ttl"`
+	}
+
+	// caaRecordParams restricts the CAs permitted to issue certificates,
+	// see RFC 8659. Like TXT records, they default to the zone apex.
+	caaRecordParams struct {
+		Name  string `json:"name" form:"name" query:"name"`
+		Flags uint8  `json:"flags" form:"flags" query:"flags"`
+		Tag   string `json:"tag" form:"tag" query:"tag"`
+		Value string `json:"value" form:"value" query:"value"`
+		TTL   uint32 `json:"ttl" form:"ttl" query:"ttl"`
+	}
+
+	// nsRecordParams delegates a name below the zone to the nameserver
+	// Dest. Addrs are the glue addresses of a nameserver within the zone.
+	nsRecordParams struct {
+		Name  string   `json:"name" form:"name" query:"name"`
+		Dest  string   `json:"dest" form:"dest" query:"dest"`
+		Addrs []string `json:"addresses" form:"addresses" query:"addresses"`
+		TTL   uint32   `json:"ttl" form:"ttl" query:"ttl"`
+	}
+
+	// sshfpRecordParams publishes the fingerprint of an SSH host key.
+	sshfpRecordParams struct {
+		Name        string `json:"name" form:"name" query:"name"`
+		Algorithm   uint8  `json:"algorithm" form:"algorithm" query:"algorithm"`
+		Type        uint8  `json:"fingerprint_type" form:"fingerprint_type" query:"fingerprint_type"`
+		Fingerprint string `json:"fingerprint" form:"fingerprint" query:"fingerprint"`
+		TTL         uint32 `json:"ttl" form:"ttl" query:"ttl"`
+	}
+
+	// tlsaRecordParams associates a TLS certificate with the service at
+	// _port._proto, below the host Name or the zone itself.
+	tlsaRecordParams struct {
+		Port         uint16 `json:"port" form:"port" query:"port"`
+		Proto        string `json:"proto" form:"proto" query:"proto"`
+		Name         string `json:"name" form:"name" query:"name"`
+		Usage        uint8  `json:"usage" form:"usage" query:"usage"`
+		Selector     uint8  `json:"selector" form:"selector" query:"selector"`
+		MatchingType uint8  `json:"matching_type" form:"matching_type" query:"matching_type"`
+		Certificate  string `json:"certificate" form:"certificate" query:"certificate"`
+		TTL          uint32 `json:"ttl" form:"ttl" query:"ttl"`
 	}
 
 	limiter struct {
@@ -65,12 +147,17 @@ type (
 
 var (
 	// Some regular expressions for field validation.
-	validZoneName = regexp.MustCompile(`^([a-zA-Z0-9\-]+[a-zA-Z0-9\-]*){1,61}`)
+	validZoneName = regexp.MustCompile(`^[a-zA-Z0-9\-]{1,61}$`)
 	validARecord  = regexp.MustCompile(`^([a-zA-Z0-9\-]+[a-zA-Z0-9]*){1,61}$`)
 	validService  = regexp.MustCompile(`^([a-zA-Z0-9]+[a-zA-Z0-9\-]*){1,61}$`)
 	validProto    = regexp.MustCompile(`^([a-zA-Z0-9]*){1,16}$`)
+	validTXTName  = regexp.MustCompile(`^[a-zA-Z0-9_\-]{1,63}(\.[a-zA-Z0-9_\-]{1,63})*$`)
 	validHostname = regexp.MustCompile(`^([a-zA-Z0-9_\-\.]*){4,253}$`)
 	validIPv4     = regexp.MustCompile(`^(?:(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)$`)
+	validCAAValue = regexp.MustCompile(`^[\x20-\x21\x23-\x5b\x5d-\x7e]{1,255}$`)
+	validCAAParam = regexp.MustCompile(`^[a-zA-Z0-9]+(-*[a-zA-Z0-9]+)*=[\x21-\x3a\x3c-\x7e]*$`)
+	validIssuer   = regexp.MustCompile(`^[a-zA-Z0-9\-]{1,63}(\.[a-zA-Z0-9\-]{1,63})*$`)
+	validHex      = regexp.MustCompile(`^[0-9a-fA-F]+$`)
 
 	// rate-limit
 	limitMutexRegister sync.Mutex
@@ -79,12 +166,24 @@ var (
 	limitUpdates       = map[string]*limiter{}
 )
 
-var configTemplate = `zone "{{ .ZoneFQDN }}" IN {
-    type master;
-    file "{{ .ZoneFile }}";
-    allow-update { rndc-key; };
-};
-`
+// allowUpdate reports whether another request with the key of zone with the
+// given ID is within the rate limit of one per second with a burst of ten.
+// Requests signed with a key or authenticated with one of its access tokens
+// count against the limit of the key.
+func allowUpdate(zone, keyID string) bool {
+	limitMutexUpdates.Lock()
+	defer limitMutexUpdates.Unlock()
+
+	key := zone + "/" + keyID
+	l, ok := limitUpdates[key]
+	if !ok {
+		l = &limiter{
+			Limiter: rate.NewLimiter(1, 10),
+		}
+		limitUpdates[key] = l
+	}
+	return l.Limiter.Allow()
+}
 
 func (aParams aRecordParams) isValid() bool {
 	if aParams.Name == "" {
@@ -102,6 +201,32 @@ func (aParams aRecordParams) isValid() bool {
 	return true
 }
 
+func (aaaaParams aaaaRecordParams) isValid() bool {
+	if aaaaParams.Name == "" {
+		return false
+	}
+	if !validARecord.MatchString(aaaaParams.Name) {
+		return false
+	}
+	if aaaaParams.Addr == "" {
+		return false
+	}
+	if parseIPv6(aaaaParams.Addr) == nil {
+		return false
+	}
+	return true
+}
+
+// parseIPv6 returns the parsed address if s is a plain IPv6 address. IPv4 and
+// IPv4-mapped addresses are rejected, they belong into A records.
+func parseIPv6(s string) net.IP {
+	ip := net.ParseIP(s)
+	if ip == nil || ip.To4() != nil {
+		return nil
+	}
+	return ip
+}
+
 func (srvParams srvRecordParams) isValid() bool {
 	if srvParams.Srv == "" {
 		return false
@@ -125,13 +250,24 @@ func (srvParams srvRecordParams) isValid() bool {
 }
 
 func (mxParams mxRecordParams) isValid() bool {
+	if _, err := strconv.ParseUint(mxParams.Pref, 10, 16); err != nil {
+		return false
+	}
 	if mxParams.Name == "" {
 		return false
 	}
+	if !validHostname.MatchString(mxParams.Name) {
+		return false
+	}
 	return true
 }
 
 func (txtParams txtRecordParams) isValid() bool {
+	// The name is optional, without it the record is created at the zone
+	// apex.
+	if txtParams.Name != "" && !validTXTName.MatchString(txtParams.Name) {
+		return false
+	}
 	if txtParams.Value == "" {
 		return false
 	}
@@ -157,15 +293,289 @@ func (cnameParams cnameRecordParams) isValid() bool {
 	return true
 }
 
+func (caaParams caaRecordParams) isValid() bool {
+	if caaParams.Name != "" && !validTXTName.MatchString(caaParams.Name) {
+		return false
+	}
+	// Only the issuer critical flag is defined, all other bits are reserved.
+	if caaParams.Flags&^caaFlagCritical != 0 {
+		return false
+	}
+	if !validCAAValue.MatchString(caaParams.Value) {
+		return false
+	}
+	switch strings.ToLower(caaParams.Tag) {
+	case "issue", "issuewild":
+		return validCAAIssue(caaParams.Value)
+	case "iodef":
+		return validCAAIodef(caaParams.Value)
+	}
+	return false
+}
+
+// validCAAIssue reports whether value is a valid issue or issuewild value:
+// the domain name of the CA, followed by optional key=value parameters
+// separated by semicolons. Without a domain name no CA may issue.
+func validCAAIssue(value string) bool {
+	parts := strings.Split(value, ";")
+	if issuer := strings.TrimSpace(parts[0]); issuer != "" && !validIssuer.MatchString(issuer) {
+		return false
+	}
+	for i, param := range parts[1:] {
+		param = strings.TrimSpace(param)
+		// A trailing semicolon is permitted.
+		if param == "" && i == len(parts)-2 {
+			continue
+		}
+		if !validCAAParam.MatchString(param) {
+			return false
+		}
+	}
+	return true
+}
+
+// validCAAIodef reports whether value is a valid iodef value, a mailto, http
+// or https URL to report invalid certificate requests to.
+func validCAAIodef(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "mailto":
+		return strings.Contains(u.Opaque, "@")
+	case "http", "https":
+		return u.Host != ""
+	}
+	return false
+}
+
+func (nsParams nsRecordParams) isValid() bool {
+	// The zone itself is always served by cion.
+	if nsParams.Name == "" {
+		return false
+	}
+	if !validTXTName.MatchString(nsParams.Name) {
+		return false
+	}
+	if nsParams.Dest == "" {
+		return false
+	}
+	if !validHostname.MatchString(nsParams.Dest) {
+		return false
+	}
+	for _, addr := range nsParams.Addrs {
+		if !validIPv4.MatchString(addr) && parseIPv6(addr) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (sshfpParams sshfpRecordParams) isValid() bool {
+	if sshfpParams.Name == "" {
+		return false
+	}
+	if !validARecord.MatchString(sshfpParams.Name) {
+		return false
+	}
+	if !sshfpAlgorithms[sshfpParams.Algorithm] {
+		return false
+	}
+	size, ok := sshfpTypes[sshfpParams.Type]
+	if !ok {
+		return false
+	}
+	return validHexSize(sshfpParams.Fingerprint, size)
+}
+
+func (tlsaParams tlsaRecordParams) isValid() bool {
+	if tlsaParams.Port == 0 {
+		return false
+	}
+	if tlsaParams.Proto == "" {
+		return false
+	}
+	if !validProto.MatchString(tlsaParams.Proto) {
+		return false
+	}
+	// The host is optional, without it the service is one of the zone
+	// itself.
+	if tlsaParams.Name != "" && !validTXTName.MatchString(tlsaParams.Name) {
+		return false
+	}
+	// Usages PKIX-TA, PKIX-EE, DANE-TA and DANE-EE, selectors for the full
+	// certificate and its public key.
+	if tlsaParams.Usage > 3 || tlsaParams.Selector > 1 {
+		return false
+	}
+	size, ok := tlsaMatchingTypes[tlsaParams.MatchingType]
+	if !ok {
+		return false
+	}
+	if size == 0 {
+		return validHex.MatchString(tlsaParams.Certificate) &&
+			len(tlsaParams.Certificate)%2 == 0 &&
+			len(tlsaParams.Certificate) <= 2*tlsaMaxData
+	}
+	return validHexSize(tlsaParams.Certificate, size)
+}
+
+// validHexSize reports whether s is the hex encoding of exactly size octets.
+func validHexSize(s string, size int) bool {
+	return len(s) == 2*size && validHex.MatchString(s)
+}
+
+func (aParams aRecordParams) rr(zone string) dns.RR {
+	return &dns.A{
+		Hdr: ttlHeader(recordName(zone, aParams.Name), dns.TypeA, aParams.TTL),
+		A:   net.ParseIP(aParams.Addr),
+	}
+}
+
+func (aaaaParams aaaaRecordParams) rr(zone string) dns.RR {
+	return &dns.AAAA{
+		Hdr:  ttlHeader(recordName(zone, aaaaParams.Name), dns.TypeAAAA, aaaaParams.TTL),
+		AAAA: parseIPv6(aaaaParams.Addr),
+	}
+}
+
+func (mxParams mxRecordParams) rr(zone string) dns.RR {
+	pref, _ := strconv.ParseUint(mxParams.Pref, 10, 16)
+	return &dns.MX{
+		Hdr:        ttlHeader(recordName(zone), dns.TypeMX, mxParams.TTL),
+		Preference: uint16(pref),
+		Mx:         dns.Fqdn(mxParams.Name),
+	}
+}
+
+func (srvParams srvRecordParams) rr(zone string) dns.RR {
+	return &dns.SRV{
+		Hdr:      ttlHeader(serviceName(zone, srvParams.Srv, srvParams.Proto, ""), dns.TypeSRV, srvParams.TTL),
+		Priority: srvParams.Prio,
+		Weight:   srvParams.Weight,
+		Port:     srvParams.Port,
+		Target:   dns.Fqdn(srvParams.Name),
+	}
+}
+
+func (txtParams txtRecordParams) rr(zone string) dns.RR {
+	// A single character-string is limited to 255 octets, longer values are
+	// split across several strings of the same record.
+	txt := []string{}
+	value := txtParams.Value
+	for len(value) > 255 {
+		txt = append(txt, value[:255])
+		value = value[255:]
+	}
+	txt = append(txt, value)
+
+	name := recordName(zone)
+	if txtParams.Name != "" {
+		name = recordName(zone, txtParams.Name)
+	}
+	return &dns.TXT{
+		Hdr: ttlHeader(name, dns.TypeTXT, txtParams.TTL),
+		Txt: txt,
+	}
+}
+
+func (cnameParams cnameRecordParams) rr(zone string) dns.RR {
+	return &dns.CNAME{
+		Hdr:    ttlHeader(recordName(zone, cnameParams.Name), dns.TypeCNAME, cnameParams.TTL),
+		Target: recordName(zone, cnameParams.Dest),
+	}
+}
+
+func (caaParams caaRecordParams) rr(zone string) dns.RR {
+	name := recordName(zone)
+	if caaParams.Name != "" {
+		name = recordName(zone, caaParams.Name)
+	}
+	return &dns.CAA{
+		Hdr:   ttlHeader(name, dns.TypeCAA, caaParams.TTL),
+		Flag:  caaParams.Flags,
+		Tag:   strings.ToLower(caaParams.Tag),
+		Value: caaParams.Value,
+	}
+}
+
+func (nsParams nsRecordParams) rr(zone string) dns.RR {
+	return &dns.NS{
+		Hdr: ttlHeader(recordName(zone, nsParams.Name), dns.TypeNS, nsParams.TTL),
+		Ns:  dns.Fqdn(nsParams.Dest),
+	}
+}
+
+// glue returns the address records of the nameserver, with the TTL of the
+// delegation.
+func (nsParams nsRecordParams) glue() []dns.RR {
+	rrs := []dns.RR{}
+	for _, addr := range nsParams.Addrs {
+		if validIPv4.MatchString(addr) {
+			rrs = append(rrs, &dns.A{
+				Hdr: ttlHeader(dns.Fqdn(nsParams.Dest), dns.TypeA, nsParams.TTL),
+				A:   net.ParseIP(addr),
+			})
+		} else {
+			rrs = append(rrs, &dns.AAAA{
+				Hdr:  ttlHeader(dns.Fqdn(nsParams.Dest), dns.TypeAAAA, nsParams.TTL),
+				AAAA: parseIPv6(addr),
+			})
+		}
+	}
+	return rrs
+}
+
+func (sshfpParams sshfpRecordParams) rr(zone string) dns.RR {
+	return &dns.SSHFP{
+		Hdr:         ttlHeader(recordName(zone, sshfpParams.Name), dns.TypeSSHFP, sshfpParams.TTL),
+		Algorithm:   sshfpParams.Algorithm,
+		Type:        sshfpParams.Type,
+		FingerPrint: strings.ToLower(sshfpParams.Fingerprint),
+	}
+}
+
+func (tlsaParams tlsaRecordParams) rr(zone string) dns.RR {
+	port := strconv.Itoa(int(tlsaParams.Port))
+	return &dns.TLSA{
+		Hdr:          ttlHeader(serviceName(zone, port, tlsaParams.Proto, tlsaParams.Name), dns.TypeTLSA, tlsaParams.TTL),
+		Usage:        tlsaParams.Usage,
+		Selector:     tlsaParams.Selector,
+		MatchingType: tlsaParams.MatchingType,
+		Certificate:  strings.ToLower(tlsaParams.Certificate),
+	}
+}
+
+// serviceName returns the name _service._proto within zone, below host
+// unless it is empty.
+func serviceName(zone, service, proto, host string) string {
+	labels := []string{"_" + service, "_" + proto}
+	if host != "" {
+		labels = append(labels, host)
+	}
+	return recordName(zone, labels...)
+}
+
+// newAuthKey generates a unique authentication key via sha256(uuid4()).
+func newAuthKey() (string, error) {
+	uuid, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(uuid.Bytes())
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // createZone is the echo handler for registering a zone.
 // It returns
-// - http202 and an auth_key if the zone was registered successfully
-// - http423 if the zone is already taken
-// - http429 if more than one zone is registered by the same IP within 24h
+//   - http202 and an auth_key if the zone was registered successfully, plus a
+//     tsig_key if one was requested
+//   - http423 if the zone is already taken or reserved
+//   - http429 if more than one zone is registered by the same IP within 24h
 func createZone(c echo.Context) error {
-	remote := c.Request().RemoteAddr
-	parts := strings.Split(remote, ":")
-	addr := strings.Join(parts[:len(parts)-1], ":")
+	addr := clientAddr(c.Request()).String()
 
 	rateLimit := time.Hour * 24
 
@@ -177,10 +587,11 @@ func createZone(c echo.Context) error {
 		if time.Since(lastRegistration) < time.Duration(rateLimit) {
 			log.Printf("warning: registration limit reached for: %s\n", addr)
 			d := (time.Duration(rateLimit) - time.Since(lastRegistration)).Round(time.Second)
-			return echo.NewHTTPError(
-				429,
+			return newError(
+				http.StatusTooManyRequests,
+				errRateLimited,
 				fmt.Sprintf(
-					"next registration is possible in %02dh%02dm%02ds", (d/time.Hour), (d/time.Minute), (d/time.Second),
+					"next registration is possible in %02dh%02dm%02ds", d/time.Hour, d%time.Hour/time.Minute, d%time.Minute/time.Second,
 				),
 			)
 		}
@@ -189,32 +600,49 @@ func createZone(c echo.Context) error {
 	zone := new(zone)
 	err := c.Bind(zone)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "request parameters malformed")
+		return newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed")
 	}
 
-	// If a corresponding key-file already exists, the zone is not available.
-	filePath := filepath.Join(CionKeyDir, zone.Zone+".key")
-	_, err = os.Stat(filePath)
-	if err == nil {
-		return echo.NewHTTPError(http.StatusLocked, "namespace already occupied")
+	if !validZoneName.MatchString(zone.Zone) {
+		return newError(http.StatusBadRequest, errInvalidParameters, "invalid charachters")
+	}
+	if reservedZone(zone.Zone) {
+		return newError(http.StatusLocked, errZoneTaken, "namespace reserved")
 	}
 
-	if !validZoneName.MatchString(zone.Zone) {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid charachters")
+	// If a corresponding key already exists, the zone is not available.
+	if keys.Exists(zone.Zone) {
+		return newError(http.StatusLocked, errZoneTaken, "namespace already occupied")
 	}
 
-	// Generate a unique authentication key via sha256(uuid4())
-	uuid, err := uuid.NewV4()
+	key, err := newAuthKey()
 	if err != nil {
 		return err
 	}
-	h := sha256.New()
-	h.Write(uuid.Bytes())
-	key := hex.EncodeToString(h.Sum(nil))
 
-	// Save the key to disk, "persisting the account".
-	ioutil.WriteFile(filePath, []byte(key), os.FileMode(0600))
-	exec.Command(fmt.Sprintf("chown named. %s", filePath))
+	// Mint a TSIG key for direct updates if requested. This is done first,
+	// so that the namespace remains available if it fails.
+	zone.TSIGKey = nil
+	if zone.TSIG {
+		zone.TSIGKey, err = createZoneKey(zone.Zone)
+		if err != nil {
+			log.Println("error: can not create TSIG key:", err)
+			return newError(http.StatusInternalServerError, errInternal, "can not create TSIG key")
+		}
+	}
+
+	// Save the hashed key to disk, "persisting the account". The plain key
+	// is only ever handed out once, in this response.
+	err = keys.Set(zone.Zone, []byte(key))
+	if err != nil {
+		log.Println("error: can not persist key:", err)
+		if zone.TSIGKey != nil {
+			if err := deleteZoneKey(zone.Zone); err != nil {
+				log.Println("error: can not revoke TSIG key:", err)
+			}
+		}
+		return newError(http.StatusInternalServerError, errInternal, "can not register namespace")
+	}
 
 	// Add auth-key to response and return it.
 	zone.AuthKey = key
@@ -225,33 +653,142 @@ func createZone(c echo.Context) error {
 	return c.JSON(http.StatusAccepted, zone)
 }
 
+// deleteZone is the echo handler for deleting a zone. All records managed for
+// the zone are removed and its keys are revoked. The name of the zone remains reserved
+// for the configured cool-down period.
+// It returns
+//   - http202 and the removed records if the zone was deleted
+//   - http401 if the authentication failed
+//   - http403 unless the request was authenticated with a permanent key with
+//     full access
+//   - http409 if the records were modified concurrently
+func deleteZone(c echo.Context) error {
+	cionHeaders := c.Get("cion_headers").(my_middleware.CionHeaders)
+	zone := cionHeaders.Zone
+
+	if !allowUpdate(cionHeaders.Zone, cionHeaders.KeyID) {
+		log.Printf("warning: client reached update limit: %s\n", clientAddr(c.Request()))
+		return newError(http.StatusTooManyRequests, errRateLimited, "one update per second with max burst of ten, please")
+	}
+	if err := requireOwner(c); err != nil {
+		return err
+	}
+
+	cur, err := store.List(recordName(zone))
+	if err != nil {
+		log.Println("error:", err)
+		return newError(http.StatusInternalServerError, errStoreUnavailable, "record store not available")
+	}
+	cur = managedRecords(zone, cur)
+
+	res := recordResponse{
+		Operation: opDeleteZone,
+		Zone:      zone,
+		Changed:   true,
+		DryRun:    cionHeaders.DryRun,
+		Diff:      newDiff(cur, nil),
+		Records:   newRecords(nil),
+	}
+	if res.DryRun {
+		return c.JSON(http.StatusOK, res)
+	}
+
+	// Revoke the TSIG key first, so that no records can be added directly
+	// while the zone is emptied.
+	if err := deleteZoneKey(zone); err != nil {
+		log.Println("error: can not revoke TSIG key:", err)
+		return newError(http.StatusInternalServerError, errInternal, "can not revoke TSIG key")
+	}
+
+	err = store.Replace(recordName(zone), cur, nil)
+	if err == backend.ErrConflict {
+		return newError(http.StatusConflict, errConflict, "records were modified concurrently, please retry")
+	} else if err != nil {
+		log.Println("error:", err)
+		return newError(http.StatusInternalServerError, errUpdateFailed, "update failed")
+	}
+
+	if err := keys.Delete(zone, config.Config().ZoneCooldown); err != nil {
+		log.Println("error: can not revoke key:", err)
+		return newError(http.StatusInternalServerError, errInternal, "can not revoke key")
+	}
+	log.Printf("Deleted zone: %s\n", zone)
+
+	return c.JSON(http.StatusAccepted, res)
+}
+
+// reservedZone reports whether name is reserved for the infrastructure of the
+// root domain, e.g. its nameservers, and can not be registered.
+func reservedZone(name string) bool {
+	cfg := config.Config()
+	reserved := append([]string{cfg.NS1Hostname, cfg.NS2Hostname}, cfg.ReservedZones...)
+	root := dns.CanonicalName(cfg.RootDomain)
+	for _, host := range cfg.TLSHosts {
+		host = dns.CanonicalName(host)
+		if host != root && dns.IsSubDomain(root, host) {
+			labels := dns.SplitDomainName(strings.TrimSuffix(host, "."+root))
+			reserved = append(reserved, labels[len(labels)-1])
+		}
+	}
+	for _, r := range reserved {
+		if strings.EqualFold(name, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// managedRecords returns those of rrs, the records of zone, which are managed
+// by cion on behalf of the zone. Records of other types, the nameservers of
+// the zone itself and the addresses of the nameservers of the root domain are
+// left out.
+func managedRecords(zone string, rrs []dns.RR) []dns.RR {
+	cfg := config.Config()
+	apex := recordName(zone)
+	nameservers := map[string]bool{
+		dns.CanonicalName(cfg.NS1Hostname + "." + cfg.RootDomain): true,
+		dns.CanonicalName(cfg.NS2Hostname + "." + cfg.RootDomain): true,
+	}
+
+	managed := []dns.RR{}
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		name := dns.CanonicalName(hdr.Name)
+		switch {
+		case !managedTypes[hdr.Rrtype]:
+		case hdr.Rrtype == dns.TypeNS && name == dns.CanonicalName(apex):
+		case (hdr.Rrtype == dns.TypeA || hdr.Rrtype == dns.TypeAAAA) && nameservers[name]:
+		default:
+			managed = append(managed, rr)
+		}
+	}
+	return managed
+}
+
 // createUpdateOrDeleteRecord is the echo handler for adding/update records.
 // It returns
-// - http200 if the record was added/updated successfully
+// - http202 and the resulting RRset if the record was added/updated/deleted
 // - http400 if the request was malformed
 // - http401 if the authentication failed
+// - http404 if the record to delete does not exist
+// - http409 if the RRset was modified concurrently
 func createUpdateOrDeleteRecord(c echo.Context) error {
 	cionHeaders := c.Get("cion_headers").(my_middleware.CionHeaders)
 
-	limitMutexUpdates.Lock()
-	key := cionHeaders.AuthKey
-	l, ok := limitUpdates[key]
-	if !ok {
-		limitUpdates[key] = &limiter{
-			Limiter: rate.NewLimiter(1, 10),
-		}
-		l = limitUpdates[key]
+	if !allowUpdate(cionHeaders.Zone, cionHeaders.KeyID) {
+		log.Printf("warning: client reached update limit: %s\n", clientAddr(c.Request()))
+		return newError(http.StatusTooManyRequests, errRateLimited, "one update per second with max burst of ten, please")
 	}
-	allowed := !l.Limiter.Allow()
-	limitMutexUpdates.Unlock()
-	if allowed {
-		log.Printf("warning: client reached update limit: %s\n", c.Request().RemoteAddr)
-		return echo.NewHTTPError(429, "one update per second with max burst of ten, please")
+
+	if cionHeaders.Scope != nil && cionHeaders.Scope.ReadOnly {
+		return newError(http.StatusForbidden, errForbidden, "key is read-only")
 	}
 
 	if cionHeaders.UpdateType != "" {
 		if strings.ToLower(cionHeaders.UpdateType) == "a" {
 			return createOrUpdateARecord(c, cionHeaders.Zone)
+		} else if strings.ToLower(cionHeaders.UpdateType) == "aaaa" {
+			return createOrUpdateAAAARecord(c, cionHeaders.Zone)
 		} else if strings.ToLower(cionHeaders.UpdateType) == "srv" {
 			return createOrUpdateSRVRecord(c, cionHeaders.Zone)
 		} else if strings.ToLower(cionHeaders.UpdateType) == "mx" {
@@ -260,14 +797,29 @@ func createUpdateOrDeleteRecord(c echo.Context) error {
 			return createOrUpdateTXTRecord(c, cionHeaders.Zone)
 		} else if strings.ToLower(cionHeaders.UpdateType) == "cname" {
 			return createOrUpdateCNAMERecord(c, cionHeaders.Zone)
+		} else if strings.ToLower(cionHeaders.UpdateType) == "caa" {
+			return createOrUpdateCAARecord(c, cionHeaders.Zone)
+		} else if strings.ToLower(cionHeaders.UpdateType) == "ns" {
+			return createOrUpdateNSRecord(c, cionHeaders.Zone)
+		} else if strings.ToLower(cionHeaders.UpdateType) == "sshfp" {
+			return createOrUpdateSSHFPRecord(c, cionHeaders.Zone)
+		} else if strings.ToLower(cionHeaders.UpdateType) == "tlsa" {
+			return createOrUpdateTLSARecord(c, cionHeaders.Zone)
+		} else if strings.ToLower(cionHeaders.UpdateType) == "svcb" {
+			return createOrUpdateSVCBRecord(c, cionHeaders.Zone, dns.TypeSVCB)
+		} else if strings.ToLower(cionHeaders.UpdateType) == "https" {
+			return createOrUpdateSVCBRecord(c, cionHeaders.Zone, dns.TypeHTTPS)
 		}
-		return echo.NewHTTPError(
+		return newError(
 			http.StatusBadRequest,
+			errInvalidType,
 			fmt.Sprintf("invalid update type: %s", cionHeaders.UpdateType),
 		)
 	} else if cionHeaders.DeleteType != "" {
 		if strings.ToLower(cionHeaders.DeleteType) == "a" {
 			return deleteARecord(c, cionHeaders.Zone)
+		} else if strings.ToLower(cionHeaders.DeleteType) == "aaaa" {
+			return deleteAAAARecord(c, cionHeaders.Zone)
 		} else if strings.ToLower(cionHeaders.DeleteType) == "mx" {
 			return deleteMXRecord(c, cionHeaders.Zone)
 		} else if strings.ToLower(cionHeaders.DeleteType) == "srv" {
@@ -276,313 +828,447 @@ func createUpdateOrDeleteRecord(c echo.Context) error {
 			return deleteTXTRecord(c, cionHeaders.Zone)
 		} else if strings.ToLower(cionHeaders.DeleteType) == "cname" {
 			return deleteCNAMERecord(c, cionHeaders.Zone)
+		} else if strings.ToLower(cionHeaders.DeleteType) == "caa" {
+			return deleteCAARecord(c, cionHeaders.Zone)
+		} else if strings.ToLower(cionHeaders.DeleteType) == "ns" {
+			return deleteNSRecord(c, cionHeaders.Zone)
+		} else if strings.ToLower(cionHeaders.DeleteType) == "sshfp" {
+			return deleteSSHFPRecord(c, cionHeaders.Zone)
+		} else if strings.ToLower(cionHeaders.DeleteType) == "tlsa" {
+			return deleteTLSARecord(c, cionHeaders.Zone)
+		} else if strings.ToLower(cionHeaders.DeleteType) == "svcb" {
+			return deleteSVCBRecord(c, cionHeaders.Zone, dns.TypeSVCB)
+		} else if strings.ToLower(cionHeaders.DeleteType) == "https" {
+			return deleteSVCBRecord(c, cionHeaders.Zone, dns.TypeHTTPS)
 		}
 
-		return echo.NewHTTPError(
+		return newError(
 			http.StatusBadRequest,
-			fmt.Sprintf("invalid delete type: %s", cionHeaders.UpdateType),
+			errInvalidType,
+			fmt.Sprintf("invalid delete type: %s", cionHeaders.DeleteType),
 		)
 	}
-	return echo.NewHTTPError(
+	return newError(
 		http.StatusBadRequest,
+		errMissingType,
 		"please specify X-Cion-Update-Type or X-Cion-Delete-Type header!",
 	)
 }
 
-func getAParams(c echo.Context, zone string, delete bool) (*exec.Cmd, error) {
+func getAParams(c echo.Context) (*aRecordParams, error) {
 	aParams := new(aRecordParams)
 	if err := c.Bind(aParams); err != nil {
-		return nil, echo.NewHTTPError(
-			http.StatusBadRequest,
-			"request parameters malformed!",
-		)
+		return nil, newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
 	}
 
-	if !aParams.isValid() {
-		return nil, echo.NewHTTPError(
-			http.StatusBadRequest,
-			"request parameters not valid or missing!",
-		)
+	// Without an address the client publishes its own one.
+	if aParams.Addr == "" || strings.ToLower(aParams.Addr) == "auto" {
+		ip := clientAddr(c.Request())
+		if ip == nil || ip.To4() == nil {
+			return nil, newError(
+				http.StatusBadRequest,
+				errUnknownAddress,
+				"can not detect IPv4 address of client, please specify the address!",
+			)
+		}
+		aParams.Addr = ip.To4().String()
 	}
 
-	os.Setenv("CION_DEPLOY_UPDATE", "yes")
-	if delete {
-		os.Setenv("CION_DELETE_ONLY", "yes")
+	if !aParams.isValid() {
+		return nil, newError(http.StatusBadRequest, errInvalidParameters, "request parameters not valid or missing!")
 	}
-	cmd := exec.Command(
-		"cion_compile_update_a",
-		zone,
-		aParams.Name,
-		aParams.Addr,
-	)
-	return cmd, nil
+	return aParams, nil
 }
 
 func createOrUpdateARecord(c echo.Context, zone string) error {
-	cmd, err := getAParams(c, zone, false)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "err")
-	}
-	out, err := cmd.CombinedOutput()
+	aParams, err := getAParams(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, out)
+		return err
 	}
 
-	return c.String(http.StatusAccepted, string(out))
+	return updateRecord(c, zone, aParams.rr(zone))
 }
 
 func deleteARecord(c echo.Context, zone string) error {
-	cmd, err := getAParams(c, zone, true)
+	aParams, err := getAParams(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err)
+		return err
 	}
-	out, err := cmd.CombinedOutput()
+	return deleteRecord(c, zone, aParams.rr(zone))
+}
+
+func getAAAAParams(c echo.Context) (*aaaaRecordParams, error) {
+	aaaaParams := new(aaaaRecordParams)
+	if err := c.Bind(aaaaParams); err != nil {
+		return nil, newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
+	}
+
+	// Without an address the client publishes its own one.
+	if aaaaParams.Addr == "" || strings.ToLower(aaaaParams.Addr) == "auto" {
+		ip := clientAddr(c.Request())
+		if ip == nil || ip.To4() != nil {
+			return nil, newError(
+				http.StatusBadRequest,
+				errUnknownAddress,
+				"can not detect IPv6 address of client, please specify the address!",
+			)
+		}
+		aaaaParams.Addr = ip.String()
+	}
+
+	if !aaaaParams.isValid() {
+		return nil, newError(http.StatusBadRequest, errInvalidParameters, "request parameters not valid or missing!")
+	}
+
+	// Normalize the address to its canonical RFC 5952 form, so that it
+	// matches the way the nameserver presents existing records.
+	aaaaParams.Addr = parseIPv6(aaaaParams.Addr).String()
+
+	return aaaaParams, nil
+}
+
+func createOrUpdateAAAARecord(c echo.Context, zone string) error {
+	aaaaParams, err := getAAAAParams(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, out)
+		return err
 	}
 
-	return c.String(http.StatusAccepted, string(out))
+	return updateRecord(c, zone, aaaaParams.rr(zone))
 }
 
-func getMXParams(c echo.Context, zone string, delete bool) (*exec.Cmd, error) {
+func deleteAAAARecord(c echo.Context, zone string) error {
+	aaaaParams, err := getAAAAParams(c)
+	if err != nil {
+		return err
+	}
+	return deleteRecord(c, zone, aaaaParams.rr(zone))
+}
+
+func getMXParams(c echo.Context) (*mxRecordParams, error) {
 	mxParams := new(mxRecordParams)
 	if err := c.Bind(mxParams); err != nil {
-		return nil, echo.NewHTTPError(
-			http.StatusBadRequest,
-			"request parameters malformed!",
-		)
+		return nil, newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
 	}
 
 	if !mxParams.isValid() {
-		return nil, echo.NewHTTPError(
-			http.StatusBadRequest,
-			"request parameters not valid or missing!",
-		)
+		return nil, newError(http.StatusBadRequest, errInvalidParameters, "request parameters not valid or missing!")
 	}
-
-	os.Setenv("CION_DEPLOY_UPDATE", "yes")
-	if delete {
-		os.Setenv("CION_DELETE_ONLY", "yes")
-	}
-	cmd := exec.Command(
-		"cion_compile_update_mx",
-		zone,
-		mxParams.Pref,
-		mxParams.Name,
-	)
-
-	return cmd, nil
+	return mxParams, nil
 }
 
 func createOrUpdateMXRecord(c echo.Context, zone string) error {
-	cmd, err := getMXParams(c, zone, false)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err)
-	}
-	out, err := cmd.CombinedOutput()
+	mxParams, err := getMXParams(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, out)
+		return err
 	}
 
-	return c.String(http.StatusAccepted, string(out))
+	return updateRecord(c, zone, mxParams.rr(zone))
 }
 
 func deleteMXRecord(c echo.Context, zone string) error {
-	cmd, err := getMXParams(c, zone, true)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err)
-	}
-	out, err := cmd.CombinedOutput()
+	mxParams, err := getMXParams(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, out)
+		return err
 	}
-
-	return c.String(http.StatusAccepted, string(out))
+	return deleteRecord(c, zone, mxParams.rr(zone))
 }
 
-func getSRVParams(c echo.Context, zone string, delete bool) (*exec.Cmd, error) {
+func getSRVParams(c echo.Context) (*srvRecordParams, error) {
 	srvParams := new(srvRecordParams)
 	if err := c.Bind(srvParams); err != nil {
-		return nil, echo.NewHTTPError(http.StatusBadRequest, "request parameters malformed!")
+		return nil, newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
 	}
 
 	if !srvParams.isValid() {
-		return nil, echo.NewHTTPError(http.StatusBadRequest, "request parameters not valid or missing!")
-	}
-
-	os.Setenv("CION_DEPLOY_UPDATE", "yes")
-	if delete {
-		os.Setenv("CION_DELETE_ONLY", "yes")
-	}
-	cmd := exec.Command(
-		"cion_compile_update_srv",
-		zone,
-		srvParams.Srv,
-		srvParams.Proto,
-		fmt.Sprintf("%d", srvParams.Prio),
-		fmt.Sprintf("%d", srvParams.Weight),
-		fmt.Sprintf("%d", srvParams.Port),
-		srvParams.Name,
-	)
-	return cmd, nil
+		return nil, newError(http.StatusBadRequest, errInvalidParameters, "request parameters not valid or missing!")
+	}
+	return srvParams, nil
 }
 
 func createOrUpdateSRVRecord(c echo.Context, zone string) error {
-	cmd, err := getSRVParams(c, zone, false)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err)
-	}
-	out, err := cmd.CombinedOutput()
+	srvParams, err := getSRVParams(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, out)
+		return err
 	}
 
-	return c.String(http.StatusAccepted, string(out))
+	return updateRecord(c, zone, srvParams.rr(zone))
 }
 
 func deleteSRVRecord(c echo.Context, zone string) error {
-	cmd, err := getSRVParams(c, zone, true)
+	srvParams, err := getSRVParams(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err)
-	}
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, out)
+		return err
 	}
-
-	return c.String(http.StatusAccepted, string(out))
+	return deleteRecord(c, zone, srvParams.rr(zone))
 }
 
-func getTXTParams(c echo.Context, zone string, delete bool) (*exec.Cmd, error) {
+func getTXTParams(c echo.Context) (*txtRecordParams, error) {
 	txtParams := new(txtRecordParams)
 	if err := c.Bind(txtParams); err != nil {
-		return nil, echo.NewHTTPError(
-			http.StatusBadRequest,
-			"request parameters malformed!",
-		)
+		return nil, newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
 	}
 
 	if !txtParams.isValid() {
-		return nil, echo.NewHTTPError(
-			http.StatusBadRequest,
-			"request parameters not valid or missing!",
-		)
+		return nil, newError(http.StatusBadRequest, errInvalidParameters, "request parameters not valid or missing!")
 	}
+	return txtParams, nil
+}
 
-	os.Setenv("CION_DEPLOY_UPDATE", "yes")
-	if delete {
-		os.Setenv("CION_DELETE_ONLY", "yes")
+func createOrUpdateTXTRecord(c echo.Context, zone string) error {
+	txtParams, err := getTXTParams(c)
+	if err != nil {
+		return err
 	}
-	cmd := exec.Command(
-		"cion_compile_update_txt",
-		zone,
-		txtParams.Value,
-	)
 
-	return cmd, nil
+	return updateRecord(c, zone, txtParams.rr(zone))
 }
 
-func createOrUpdateTXTRecord(c echo.Context, zone string) error {
-	cmd, err := getTXTParams(c, zone, false)
+func deleteTXTRecord(c echo.Context, zone string) error {
+	txtParams, err := getTXTParams(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err)
+		return err
+	}
+	return deleteRecord(c, zone, txtParams.rr(zone))
+}
+
+func getCNAMEParams(c echo.Context) (*cnameRecordParams, error) {
+	cnameParams := new(cnameRecordParams)
+	if err := c.Bind(cnameParams); err != nil {
+		return nil, newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
+	}
+
+	if !cnameParams.isValid() {
+		return nil, newError(http.StatusBadRequest, errInvalidParameters, "request parameters not valid or missing!")
 	}
-	out, err := cmd.CombinedOutput()
+	cnameParams.Dest = strings.TrimSuffix(cnameParams.Dest, ".")
+
+	return cnameParams, nil
+}
+
+func createOrUpdateCNAMERecord(c echo.Context, zone string) error {
+	cnameParams, err := getCNAMEParams(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, out)
+		return err
 	}
 
-	return c.String(http.StatusAccepted, string(out))
+	return updateRecord(c, zone, cnameParams.rr(zone))
 }
 
-func deleteTXTRecord(c echo.Context, zone string) error {
-	cmd, err := getTXTParams(c, zone, true)
+func deleteCNAMERecord(c echo.Context, zone string) error {
+	cnameParams, err := getCNAMEParams(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err)
+		return err
+	}
+	return deleteRecord(c, zone, cnameParams.rr(zone))
+}
+
+func getCAAParams(c echo.Context) (*caaRecordParams, error) {
+	caaParams := new(caaRecordParams)
+	if err := c.Bind(caaParams); err != nil {
+		return nil, newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
 	}
-	out, err := cmd.CombinedOutput()
+
+	if !caaParams.isValid() {
+		return nil, newError(http.StatusBadRequest, errInvalidParameters, "request parameters not valid or missing!")
+	}
+	return caaParams, nil
+}
+
+func createOrUpdateCAARecord(c echo.Context, zone string) error {
+	caaParams, err := getCAAParams(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, out)
+		return err
 	}
 
-	return c.String(http.StatusAccepted, string(out))
+	return updateRecord(c, zone, caaParams.rr(zone))
 }
 
-func getCNAMEParams(c echo.Context, zone string, delete bool) (*exec.Cmd, error) {
-	cnameParams := new(cnameRecordParams)
-	if err := c.Bind(cnameParams); err != nil {
-		return nil, echo.NewHTTPError(
-			http.StatusBadRequest,
-			"request parameters malformed!",
-		)
+func deleteCAARecord(c echo.Context, zone string) error {
+	caaParams, err := getCAAParams(c)
+	if err != nil {
+		return err
 	}
+	return deleteRecord(c, zone, caaParams.rr(zone))
+}
 
-	if !cnameParams.isValid() {
-		return nil, echo.NewHTTPError(
-			http.StatusBadRequest,
-			"request parameters not valid or missing!",
-		)
+func getNSParams(c echo.Context) (*nsRecordParams, error) {
+	nsParams := new(nsRecordParams)
+	if err := c.Bind(nsParams); err != nil {
+		return nil, newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
 	}
 
-	os.Setenv("CION_DEPLOY_UPDATE", "yes")
-	cmd := exec.Command(
-		"cion_compile_update_cname",
-		zone,
-		cnameParams.Name,
-		cnameParams.Dest,
-	)
+	if nsParams.Name == "" || nsParams.Name == "@" {
+		return nil, newError(http.StatusBadRequest, errInvalidParameters, "the zone itself can not be delegated")
+	}
+	if !nsParams.isValid() {
+		return nil, newError(http.StatusBadRequest, errInvalidParameters, "request parameters not valid or missing!")
+	}
+	return nsParams, nil
+}
+
+func createOrUpdateNSRecord(c echo.Context, zone string) error {
+	nsParams, err := getNSParams(c)
+	if err != nil {
+		return err
+	}
 
-	return cmd, nil
+	rr := nsParams.rr(zone)
+	cur, rrs, err := mergeRecord(zone, rr, supersedes(rr))
+	if err != nil {
+		return err
+	}
+	cur, rrs, err = delegate(zone, cur, rrs, nsParams.glue())
+	if err != nil {
+		return err
+	}
+	return replaceRecords(c, opUpdate, zone, cur, rrs)
 }
 
-func createOrUpdateCNAMERecord(c echo.Context, zone string) error {
-	cmd, err := getCNAMEParams(c, zone, false)
+func deleteNSRecord(c echo.Context, zone string) error {
+	nsParams, err := getNSParams(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err)
+		return err
 	}
-	out, err := cmd.CombinedOutput()
+
+	rr := nsParams.rr(zone)
+	cur, err := lookupRRset(zone, rr.Header().Name, dns.TypeNS)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, out)
+		return err
+	}
+	rrs, ok := removeRecords(cur, []dns.RR{rr})
+	if !ok {
+		return newError(http.StatusNotFound, errNotFound, "no such record")
+	}
+	cur, rrs, err = delegate(zone, cur, rrs, nil)
+	if err != nil {
+		return err
+	}
+	return replaceRecords(c, opDelete, zone, cur, rrs)
+}
+
+func getSSHFPParams(c echo.Context) (*sshfpRecordParams, error) {
+	sshfpParams := new(sshfpRecordParams)
+	if err := c.Bind(sshfpParams); err != nil {
+		return nil, newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
 	}
 
-	return c.String(http.StatusAccepted, string(out))
+	if !sshfpParams.isValid() {
+		return nil, newError(http.StatusBadRequest, errInvalidParameters, "request parameters not valid or missing!")
+	}
+	return sshfpParams, nil
 }
 
-func deleteCNAMERecord(c echo.Context, zone string) error {
-	cmd, err := getCNAMEParams(c, zone, true)
+func createOrUpdateSSHFPRecord(c echo.Context, zone string) error {
+	sshfpParams, err := getSSHFPParams(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err)
+		return err
 	}
-	out, err := cmd.CombinedOutput()
+
+	return updateRecord(c, zone, sshfpParams.rr(zone))
+}
+
+func deleteSSHFPRecord(c echo.Context, zone string) error {
+	sshfpParams, err := getSSHFPParams(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, out)
+		return err
+	}
+	return deleteRecord(c, zone, sshfpParams.rr(zone))
+}
+
+func getTLSAParams(c echo.Context) (*tlsaRecordParams, error) {
+	tlsaParams := new(tlsaRecordParams)
+	if err := c.Bind(tlsaParams); err != nil {
+		return nil, newError(http.StatusBadRequest, errMalformedRequest, "request parameters malformed!")
 	}
 
-	return c.String(http.StatusAccepted, string(out))
+	if !tlsaParams.isValid() {
+		return nil, newError(http.StatusBadRequest, errInvalidParameters, "request parameters not valid or missing!")
+	}
+	return tlsaParams, nil
 }
 
+func createOrUpdateTLSARecord(c echo.Context, zone string) error {
+	tlsaParams, err := getTLSAParams(c)
+	if err != nil {
+		return err
+	}
+
+	return updateRecord(c, zone, tlsaParams.rr(zone))
+}
+
+func deleteTLSARecord(c echo.Context, zone string) error {
+	tlsaParams, err := getTLSAParams(c)
+	if err != nil {
+		return err
+	}
+	return deleteRecord(c, zone, tlsaParams.rr(zone))
+}
+
+// getRecordList is the echo handler for listing the records of a zone.
+// The records can be filtered by the query parameters type and name. Keys
+// with a restricted scope only get to see the records within their scope.
+// It returns
+//   - http200 and all matching records, as JSON or in zone file format if
+//     text/dns is accepted
+//   - http400 if the type is invalid
+//   - http401 if the authentication failed
 func getRecordList(c echo.Context) error {
 	cionHeaders := c.Get("cion_headers").(my_middleware.CionHeaders)
 
-	limitMutexUpdates.Lock()
-	key := cionHeaders.AuthKey
-	l, ok := limitUpdates[key]
-	if !ok {
-		limitUpdates[key] = &limiter{
-			Limiter: rate.NewLimiter(1, 10),
+	if !allowUpdate(cionHeaders.Zone, cionHeaders.KeyID) {
+		log.Printf("warning: client reached request limit: %s\n", clientAddr(c.Request()))
+		return newError(http.StatusTooManyRequests, errRateLimited, "one request per second with max burst of ten, please")
+	}
+
+	// Optionally filter by type and by name, relative to the zone unless
+	// fully qualified.
+	var rrtype uint16
+	if t := c.QueryParam("type"); t != "" {
+		var ok bool
+		rrtype, ok = dns.StringToType[strings.ToUpper(t)]
+		if !ok {
+			return newError(http.StatusBadRequest, errInvalidType, fmt.Sprintf("invalid type: %s", t))
 		}
-		l = limitUpdates[key]
 	}
-	allowed := !l.Limiter.Allow()
-	limitMutexUpdates.Unlock()
-	if allowed {
-		log.Printf("warning: client reached request limit: %s\n", c.Request().RemoteAddr)
-		return echo.NewHTTPError(429, "one request per second with max burst of ten, please")
+	name := c.QueryParam("name")
+	if name == "@" {
+		name = recordName(cionHeaders.Zone)
+	} else if name != "" && !strings.HasSuffix(name, ".") {
+		name = recordName(cionHeaders.Zone, name)
 	}
 
-	out, err := exec.Command("cion_list_zone", cionHeaders.Zone).Output()
+	all, err := store.List(recordName(cionHeaders.Zone))
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err)
+		log.Println("error:", err)
+		return newError(http.StatusInternalServerError, errStoreUnavailable, "record store not available")
 	}
-	return c.Blob(http.StatusOK, "text/plain", out)
+
+	rrs := []dns.RR{}
+	for _, rr := range all {
+		if rrtype != 0 && rr.Header().Rrtype != rrtype {
+			continue
+		}
+		if name != "" && !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		if !inScope(cionHeaders.Scope, cionHeaders.Zone, rr) {
+			continue
+		}
+		rrs = append(rrs, rr)
+	}
+
+	// Zone file format is available on request.
+	if acceptsZoneFile(c) {
+		out := ""
+		for _, rr := range rrs {
+			out += rr.String() + "\n"
+		}
+		return c.Blob(http.StatusOK, mimeZoneFile, []byte(out))
+	}
+
+	return c.JSON(http.StatusOK, recordResponse{
+		Operation: opList,
+		Zone:      cionHeaders.Zone,
+		Records:   newRecords(rrs),
+	})
 }
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/baccenfutter/cion/authkey"
+	my_middleware "github.com/baccenfutter/cion/middleware"
+)
+
+func TestCAARecordParams(t *testing.T) {
+	tests := []struct {
+		params caaRecordParams
+		valid  bool
+	}{
+		{caaRecordParams{Tag: "issue", Value: "letsencrypt.org"}, true},
+		{caaRecordParams{Tag: "ISSUE", Value: "letsencrypt.org"}, true},
+		{caaRecordParams{Tag: "issuewild", Value: ";"}, true},
+		{caaRecordParams{Tag: "issue", Value: "ca.example.org; account=123; validationmethods=dns-01"}, true},
+		{caaRecordParams{Tag: "issue", Value: "ca.example.org;"}, true},
+		{caaRecordParams{Name: "www", Flags: caaFlagCritical, Tag: "issue", Value: "ca.example.org"}, true},
+		{caaRecordParams{Tag: "iodef", Value: "mailto:security@example.org"}, true},
+		{caaRecordParams{Tag: "iodef", Value: "https://example.org/caa"}, true},
+		{caaRecordParams{Tag: "issue", Value: ""}, false},
+		{caaRecordParams{Tag: "issue", Value: "ca..example.org"}, false},
+		{caaRecordParams{Tag: "issue", Value: "ca.example.org; account"}, false},
+		{caaRecordParams{Tag: "issue", Value: "ca.example.org;; account=1"}, false},
+		{caaRecordParams{Tag: "issue", Value: "ca.example.org \"quoted\""}, false},
+		{caaRecordParams{Flags: 1, Tag: "issue", Value: "ca.example.org"}, false},
+		{caaRecordParams{Tag: "unknown", Value: "ca.example.org"}, false},
+		{caaRecordParams{Tag: "iodef", Value: "mailto:example.org"}, false},
+		{caaRecordParams{Tag: "iodef", Value: "ftp://example.org/caa"}, false},
+		{caaRecordParams{Name: "a b", Tag: "issue", Value: "ca.example.org"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.params.isValid(); got != tt.valid {
+			t.Errorf("%+v: isValid = %v, want %v", tt.params, got, tt.valid)
+		}
+	}
+}
+
+func TestSSHFPRecordParams(t *testing.T) {
+	tests := []struct {
+		params sshfpRecordParams
+		valid  bool
+	}{
+		{sshfpRecordParams{Name: "host", Algorithm: 4, Type: 2, Fingerprint: strings.Repeat("ab", 32)}, true},
+		{sshfpRecordParams{Name: "host", Algorithm: 1, Type: 1, Fingerprint: strings.Repeat("AB", 20)}, true},
+		{sshfpRecordParams{Algorithm: 4, Type: 2, Fingerprint: strings.Repeat("ab", 32)}, false},
+		{sshfpRecordParams{Name: "host", Algorithm: 5, Type: 2, Fingerprint: strings.Repeat("ab", 32)}, false},
+		{sshfpRecordParams{Name: "host", Algorithm: 4, Type: 3, Fingerprint: strings.Repeat("ab", 32)}, false},
+		{sshfpRecordParams{Name: "host", Algorithm: 4, Type: 2, Fingerprint: strings.Repeat("ab", 20)}, false},
+		{sshfpRecordParams{Name: "host", Algorithm: 4, Type: 2, Fingerprint: strings.Repeat("xy", 32)}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.params.isValid(); got != tt.valid {
+			t.Errorf("%+v: isValid = %v, want %v", tt.params, got, tt.valid)
+		}
+	}
+}
+
+func TestTLSARecordParams(t *testing.T) {
+	sha256 := strings.Repeat("0f", 32)
+	tests := []struct {
+		params tlsaRecordParams
+		valid  bool
+	}{
+		{tlsaRecordParams{Port: 443, Proto: "tcp", Usage: 3, Selector: 1, MatchingType: 1, Certificate: sha256}, true},
+		{tlsaRecordParams{Port: 25, Proto: "tcp", Name: "mail", Usage: 2, MatchingType: 2, Certificate: sha256 + sha256}, true},
+		{tlsaRecordParams{Port: 443, Proto: "tcp", Usage: 3, MatchingType: 0, Certificate: "3082"}, true},
+		{tlsaRecordParams{Port: 0, Proto: "tcp", Usage: 3, MatchingType: 1, Certificate: sha256}, false},
+		{tlsaRecordParams{Port: 443, Proto: "", Usage: 3, MatchingType: 1, Certificate: sha256}, false},
+		{tlsaRecordParams{Port: 443, Proto: "tcp", Usage: 4, MatchingType: 1, Certificate: sha256}, false},
+		{tlsaRecordParams{Port: 443, Proto: "tcp", Usage: 3, Selector: 2, MatchingType: 1, Certificate: sha256}, false},
+		{tlsaRecordParams{Port: 443, Proto: "tcp", Usage: 3, MatchingType: 3, Certificate: sha256}, false},
+		{tlsaRecordParams{Port: 443, Proto: "tcp", Usage: 3, MatchingType: 1, Certificate: sha256 + "00"}, false},
+		{tlsaRecordParams{Port: 443, Proto: "tcp", Usage: 3, MatchingType: 0, Certificate: "308"}, false},
+		{tlsaRecordParams{Port: 443, Proto: "tcp", Usage: 3, MatchingType: 0, Certificate: strings.Repeat("00", tlsaMaxData+1)}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.params.isValid(); got != tt.valid {
+			t.Errorf("%+v: isValid = %v, want %v", tt.params, got, tt.valid)
+		}
+	}
+}
+
+func TestTXTRecordParams(t *testing.T) {
+	label := strings.Repeat("a", 63)
+	tests := []struct {
+		params txtRecordParams
+		valid  bool
+	}{
+		{txtRecordParams{Value: "v=spf1 -all"}, true},
+		{txtRecordParams{Name: "_acme-challenge.www", Value: "token"}, true},
+		{txtRecordParams{Name: label + "." + label + "." + label, Value: "long"}, true},
+		{txtRecordParams{Name: label + "a", Value: "label too long"}, false},
+		{txtRecordParams{Name: "a..b", Value: "empty label"}, false},
+		{txtRecordParams{Name: "www"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.params.isValid(); got != tt.valid {
+			t.Errorf("%+v: isValid = %v, want %v", tt.params, got, tt.valid)
+		}
+	}
+}
+
+func TestReservedZone(t *testing.T) {
+	tests := []struct {
+		name     string
+		reserved bool
+	}{
+		{"ns1", true},
+		{"NS2", true},
+		{"www", true},
+		{"hostmaster", true},
+		{"example", false},
+		{"ns3", false},
+	}
+	for _, tt := range tests {
+		if got := reservedZone(tt.name); got != tt.reserved {
+			t.Errorf("%s: reservedZone = %v, want %v", tt.name, got, tt.reserved)
+		}
+	}
+}
+
+func TestDeleteZone(t *testing.T) {
+	defer setupTestKeys(t, "secret")()
+	owner := keys.Keys(testZone)[0]
+	expires := time.Now().Add(time.Hour)
+	expiring, err := keys.Add(testZone, []byte("expiring"), authkey.Info{Label: "ci", Expires: &expires})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rrs := []string{
+		"example.cion.test. 180 IN SOA ns1.cion.test. hostmaster.cion.test. 1 3600 600 86400 180",
+		"example.cion.test. 180 IN NS ns1.cion.test.",
+		"example.cion.test. 180 IN A 192.0.2.1",
+		"sub.example.cion.test. 180 IN NS ns.sub.example.cion.test.",
+		"ns.sub.example.cion.test. 180 IN A 192.0.2.2",
+		"_acme-challenge.example.cion.test. 180 IN TXT \"token\"",
+	}
+
+	// Only the permanent key with full access may delete the zone.
+	for _, headers := range []my_middleware.CionHeaders{
+		{KeyID: expiring.ID},
+		{KeyID: owner.ID, Token: true},
+	} {
+		c, _ := newTestContext(headers)
+		if err := store.Create(testApex, mustRRs(t, rrs...)...); err != nil {
+			t.Fatal(err)
+		}
+		assertStatus(t, deleteZone(c), http.StatusForbidden)
+		assertZone(t, rrs...)
+	}
+
+	c, _ := newTestContext(my_middleware.CionHeaders{KeyID: owner.ID})
+	if err := store.Create(testApex, mustRRs(t, rrs...)...); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteZone(c); err != nil {
+		t.Fatal(err)
+	}
+	assertZone(t,
+		"example.cion.test. 180 IN SOA ns1.cion.test. hostmaster.cion.test. 1 3600 600 86400 180",
+		"example.cion.test. 180 IN NS ns1.cion.test.",
+	)
+	if keys.Exists(testZone) {
+		t.Error("keys of the deleted zone are not revoked")
+	}
+}
+
+func TestManagedRecords(t *testing.T) {
+	// Records of a zone named like a nameserver, e.g. registered before the
+	// name was reserved.
+	rrs := mustRRs(t,
+		"ns1.cion.test. 180 IN A 192.0.2.53",
+		"ns1.cion.test. 180 IN AAAA 2001:db8::53",
+		"ns1.cion.test. 180 IN TXT \"managed\"",
+		"www.ns1.cion.test. 180 IN A 192.0.2.1",
+	)
+	got := managedRecords("ns1", rrs)
+	if len(got) != 2 || got[0] != rrs[2] || got[1] != rrs[3] {
+		t.Fatalf("managed records are %v, want %v", got, rrs[2:])
+	}
+}
@@ -0,0 +1,86 @@
+// Package authkey hashes the auth keys of zones for storage at rest and
+// verifies presented keys against them.
+//
+// Hashed keys are stored as PHC strings:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+//
+// Key files written before hashing was introduced contain the plain hex key.
+// They are still accepted, but reported as outdated so that callers can
+// upgrade them.
+package authkey
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Parameters for newly hashed keys.
+const (
+	memory     = 19 * 1024
+	iterations = 2
+	threads    = 1
+	saltLen    = 16
+	hashLen    = 32
+)
+
+var b64 = base64.RawStdEncoding
+
+// Hash returns the encoded argon2id hash of key using a random salt.
+func Hash(key []byte) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	hash := argon2.IDKey(key, salt, iterations, memory, threads, hashLen)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, memory, iterations, threads,
+		b64.EncodeToString(salt), b64.EncodeToString(hash),
+	), nil
+}
+
+// Verify reports whether key matches the stored encoded key. The comparison
+// is done in constant time.
+//
+// If the key matches but the stored form is outdated, i.e. it is a plain
+// legacy key or was hashed with different parameters, needsRehash is true
+// and the stored form should be replaced by the result of Hash.
+func Verify(encoded string, key []byte) (ok, needsRehash bool) {
+	encoded = strings.TrimSpace(encoded)
+	if !strings.HasPrefix(encoded, "$") {
+		ok = subtle.ConstantTimeCompare([]byte(encoded), key) == 1
+		return ok, ok
+	}
+
+	var version, m, t int
+	var p uint8
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, false
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &m, &t, &p); err != nil {
+		return false, false
+	}
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return false, false
+	}
+	want, err := b64.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false, false
+	}
+
+	got := argon2.IDKey(key, salt, uint32(t), uint32(m), p, uint32(len(want)))
+	ok = subtle.ConstantTimeCompare(got, want) == 1
+	needsRehash = ok && (m != memory || t != iterations || p != threads || len(want) != hashLen)
+	return ok, needsRehash
+}
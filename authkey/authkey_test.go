@@ -0,0 +1,59 @@
+package authkey
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestHashVerify(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	encoded, err := Hash(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Fatalf("unexpected encoding: %s", encoded)
+	}
+	if other, _ := Hash(key); other == encoded {
+		t.Fatal("hashes of the same key share their salt")
+	}
+
+	// A hash with outdated parameters, which still has to verify.
+	salt := []byte("0123456789abcdef")
+	weak := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, 1024, 1, 1,
+		b64.EncodeToString(salt), b64.EncodeToString(argon2.IDKey(key, salt, 1, 1024, 1, hashLen)))
+
+	tests := []struct {
+		name        string
+		encoded     string
+		key         string
+		ok          bool
+		needsRehash bool
+	}{
+		{"current hash", encoded, string(key), true, false},
+		{"surrounding whitespace", " " + encoded + "\n", string(key), true, false},
+		{"wrong key", encoded, "0123456789abcdeF", false, false},
+		{"empty key", encoded, "", false, false},
+		{"outdated parameters", weak, string(key), true, true},
+		{"outdated parameters, wrong key", weak, "wrong", false, false},
+		{"legacy plain key", "0123456789abcdef\n", string(key), true, true},
+		{"legacy plain key, wrong key", "0123456789abcdef", "0123456789abcde", false, false},
+		{"unknown algorithm", strings.Replace(encoded, "argon2id", "argon2i", 1), string(key), false, false},
+		{"unknown version", strings.Replace(encoded, "v=19", "v=16", 1), string(key), false, false},
+		{"missing hash", encoded[:strings.LastIndex(encoded, "$")+1], string(key), false, false},
+		{"malformed salt", strings.Replace(encoded, "p=1$", "p=1$!", 1), string(key), false, false},
+		{"malformed parameters", strings.Replace(encoded, "m=19456", "m=x", 1), string(key), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash := Verify(tt.encoded, []byte(tt.key))
+			if ok != tt.ok || needsRehash != tt.needsRehash {
+				t.Errorf("Verify = (%v, %v), want (%v, %v)", ok, needsRehash, tt.ok, tt.needsRehash)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package authkey
+
+import (
+	"path"
+	"strings"
+)
+
+// Scope restricts what a key is permitted to do within its zone. A nil scope
+// permits everything.
+type Scope struct {
+	// Types lists the record types the key may access, all if empty.
+	Types []string `json:"types,omitempty"`
+
+	// Names lists the names the key may access, relative to the zone and @
+	// for the zone itself, all if empty. The names may contain the wildcards
+	// of path.Match, * matches any sequence of characters including dots.
+	Names []string `json:"names,omitempty"`
+
+	// ReadOnly forbids any modifications.
+	ReadOnly bool `json:"read_only,omitempty"`
+}
+
+// Full reports whether the scope does not restrict anything.
+func (s *Scope) Full() bool {
+	return s == nil || (len(s.Types) == 0 && len(s.Names) == 0 && !s.ReadOnly)
+}
+
+// Allows reports whether records of the given type and name may be read.
+// The name is relative to the zone, @ for the zone itself.
+func (s *Scope) Allows(rrtype, name string) bool {
+	if s == nil {
+		return true
+	}
+	return s.allowsType(rrtype) && s.allowsName(name)
+}
+
+// AllowsWrite reports whether records of the given type and name may be
+// modified.
+func (s *Scope) AllowsWrite(rrtype, name string) bool {
+	return s.Allows(rrtype, name) && (s == nil || !s.ReadOnly)
+}
+
+func (s *Scope) allowsType(rrtype string) bool {
+	if len(s.Types) == 0 {
+		return true
+	}
+	for _, t := range s.Types {
+		if strings.EqualFold(t, rrtype) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Scope) allowsName(name string) bool {
+	if len(s.Names) == 0 {
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, pattern := range s.Names {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package authkey
+
+import "testing"
+
+func TestScope(t *testing.T) {
+	tests := []struct {
+		name      string
+		scope     *Scope
+		rrtype    string
+		record    string
+		read      bool
+		write     bool
+		fullScope bool
+	}{
+		{"nil scope", nil, "A", "www", true, true, true},
+		{"empty scope", &Scope{}, "TXT", "@", true, true, true},
+		{"type", &Scope{Types: []string{"A", "AAAA"}}, "a", "www", true, true, false},
+		{"other type", &Scope{Types: []string{"A", "AAAA"}}, "TXT", "www", false, false, false},
+		{"name", &Scope{Names: []string{"cam1"}}, "A", "CAM1", true, true, false},
+		{"other name", &Scope{Names: []string{"cam1"}}, "A", "cam2", false, false, false},
+		{"name below", &Scope{Names: []string{"cam1"}}, "A", "x.cam1", false, false, false},
+		{"glob", &Scope{Names: []string{"_acme-challenge*"}}, "TXT", "_acme-challenge.www", true, true, false},
+		{"glob apex", &Scope{Names: []string{"*"}}, "TXT", "@", true, true, false},
+		{"type and name", &Scope{Types: []string{"A"}, Names: []string{"cam*"}}, "AAAA", "cam1", false, false, false},
+		{"read-only", &Scope{ReadOnly: true}, "A", "www", true, false, false},
+		{"read-only other type", &Scope{Types: []string{"A"}, ReadOnly: true}, "MX", "@", false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.scope.Allows(tt.rrtype, tt.record); got != tt.read {
+				t.Errorf("Allows = %v, want %v", got, tt.read)
+			}
+			if got := tt.scope.AllowsWrite(tt.rrtype, tt.record); got != tt.write {
+				t.Errorf("AllowsWrite = %v, want %v", got, tt.write)
+			}
+			if got := tt.scope.Full(); got != tt.fullScope {
+				t.Errorf("Full = %v, want %v", got, tt.fullScope)
+			}
+		})
+	}
+}
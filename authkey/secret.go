@@ -0,0 +1,37 @@
+package authkey
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// secretSize is the size of generated secrets.
+const secretSize = 32
+
+// LoadSecret returns the hex encoded secret in path. A new secret is
+// generated if the file does not exist yet.
+func LoadSecret(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		secret := make([]byte, secretSize)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		data = []byte(hex.EncodeToString(secret) + "\n")
+		if err := ioutil.WriteFile(path, data, os.FileMode(0600)); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	secret, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(secret) < secretSize {
+		return nil, errors.New("authkey: invalid secret: " + path)
+	}
+	return secret, nil
+}
@@ -0,0 +1,79 @@
+package authkey
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// signingContext separates signing keys from other uses of the key.
+const signingContext = "cion request signing"
+
+// SigningKey derives the key requests are signed with from an auth key, it is
+// HMAC-SHA256(key, "cion request signing").
+func SigningKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingContext))
+	return mac.Sum(nil)
+}
+
+// StringToSign returns the message signed for a request: the timestamp, the
+// nonce, the method, the request URI including the query and the hex encoded
+// SHA-256 hash of the body, separated by newlines.
+func StringToSign(timestamp, nonce, method, uri string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{
+		timestamp,
+		nonce,
+		strings.ToUpper(method),
+		uri,
+		hex.EncodeToString(sum[:]),
+	}, "\n")
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of msg with signingKey.
+func Sign(signingKey []byte, msg string) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(msg))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NonceCache remembers the nonces of signed requests, so that a request can
+// not be replayed while its timestamp is still accepted.
+type NonceCache struct {
+	ttl time.Duration
+
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+// NewNonceCache returns a cache remembering nonces for ttl.
+func NewNonceCache(ttl time.Duration) *NonceCache {
+	return &NonceCache{ttl: ttl, seen: map[string]time.Time{}}
+}
+
+// Use records nonce and reports whether it was not used before.
+func (n *NonceCache) Use(nonce string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(n.pruned) > n.ttl {
+		for nonce, expires := range n.seen {
+			if now.After(expires) {
+				delete(n.seen, nonce)
+			}
+		}
+		n.pruned = now
+	}
+
+	if expires, ok := n.seen[nonce]; ok && now.Before(expires) {
+		return false
+	}
+	n.seen[nonce] = now.Add(n.ttl)
+	return true
+}
@@ -0,0 +1,50 @@
+package authkey
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStringToSign(t *testing.T) {
+	got := StringToSign("1700000000", "0123456789abcdef", "post", "/zone/example?dry_run=1", []byte(`{}`))
+	want := "1700000000\n0123456789abcdef\nPOST\n/zone/example?dry_run=1\n" +
+		"44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+	if got != want {
+		t.Errorf("StringToSign = %q, want %q", got, want)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// HMAC-SHA256 test case 2 of RFC 4231.
+	got := Sign([]byte("Jefe"), "what do ya want for nothing?")
+	want := "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+
+	key := SigningKey([]byte("key"))
+	if len(key) != 32 {
+		t.Fatalf("SigningKey has %d bytes, want 32", len(key))
+	}
+	if string(key) == string(SigningKey([]byte("other key"))) {
+		t.Error("SigningKey does not depend on the key")
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	n := NewNonceCache(50 * time.Millisecond)
+	if !n.Use("a") {
+		t.Fatal("first use of a rejected")
+	}
+	if n.Use("a") {
+		t.Fatal("replay of a accepted")
+	}
+	if !n.Use("b") {
+		t.Fatal("first use of b rejected")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !n.Use("a") {
+		t.Fatal("a rejected after it expired")
+	}
+}
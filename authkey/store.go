@@ -0,0 +1,633 @@
+package authkey
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned if a zone has no key with the given ID.
+	ErrNotFound = errors.New("authkey: no such key")
+
+	// ErrLastKey is returned when revoking or expiring the last permanent
+	// key with full access of a zone, which would lock its owner out.
+	ErrLastKey = errors.New("authkey: can not revoke the last key")
+)
+
+// DefaultLabel is the label of the key issued on registration.
+const DefaultLabel = "default"
+
+// Info describes a single key of a zone.
+type Info struct {
+	ID      string     `json:"id"`
+	Label   string     `json:"label"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+	// Scope restricts the key, it is nil for keys with full access.
+	Scope *Scope `json:"scope,omitempty"`
+}
+
+// Store keeps the stored keys of all zones in memory. The keys are read from
+// <dir>/<zone>.key files and written back there. Names of deleted zones can
+// be reserved for a while by <dir>/<zone>.reserved files, holding the time
+// the reservation ends.
+//
+// A key file holds a JSON list of all keys of the zone. Files holding a
+// single hashed or plain key, as written by earlier versions, are read as
+// the default key of the zone.
+//
+// The signing keys of the keys are stored sealed with the signing secret,
+// which has to be kept apart from the key files. Without it, keys can not
+// sign requests, see SetSigningSecret.
+type Store struct {
+	dir string
+
+	mu          sync.RWMutex
+	sealer      cipher.AEAD
+	keys        map[string][]*entry
+	reserved    map[string]time.Time
+	fingerprint string
+}
+
+// entry is a single stored key of a zone.
+type entry struct {
+	Info
+	Encoded string `json:"hash"`
+	// Signing holds the SigningKey of the key, sealed with the signing
+	// secret of the store and hex encoded. It is missing for keys stored by
+	// earlier versions or without a signing secret, until they are used.
+	Signing string `json:"signing,omitempty"`
+
+	// verified caches a fast hash of the last key that was successfully
+	// verified against Encoded, so that repeated requests do not have to pay
+	// for the slow hash every time.
+	verified *[sha256.Size]byte
+}
+
+// valid reports whether the key has not expired yet.
+func (e *entry) valid(now time.Time) bool {
+	return e.Expires == nil || now.Before(*e.Expires)
+}
+
+// Owner reports whether the key has full access and never expires, so that
+// the zone can always be managed with it.
+func (info Info) Owner() bool {
+	return info.Expires == nil && info.Scope.Full()
+}
+
+// hasOwner reports whether any of entries is an owner key.
+func hasOwner(entries []*entry) bool {
+	for _, e := range entries {
+		if e.Owner() {
+			return true
+		}
+	}
+	return false
+}
+
+// NewStore returns an empty store for the keys in dir.
+func NewStore(dir string) *Store {
+	return &Store{
+		dir:      dir,
+		keys:     map[string][]*entry{},
+		reserved: map[string]time.Time{},
+	}
+}
+
+// SetSigningSecret sets the secret the signing keys of keys stored from now on
+// are sealed with, and those of stored keys are opened with.
+func (s *Store) SetSigningSecret(secret []byte) error {
+	sum := sha256.Sum256(secret)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return err
+	}
+	sealer, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sealer = sealer
+	return nil
+}
+
+// Load (re-)reads all keys from disk and returns the number of zones loaded.
+func (s *Store) Load() (int, error) {
+	fingerprint, err := s.scan()
+	if err != nil {
+		return 0, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.key"))
+	if err != nil {
+		return 0, err
+	}
+	keys := map[string][]*entry{}
+	for _, path := range paths {
+		entries, err := readKeyFile(path)
+		if err != nil {
+			return 0, err
+		}
+		zone := strings.TrimSuffix(filepath.Base(path), ".key")
+		keys[zone] = entries
+	}
+
+	paths, err = filepath.Glob(filepath.Join(s.dir, "*.reserved"))
+	if err != nil {
+		return 0, err
+	}
+	reserved := map[string]time.Time{}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return 0, err
+		}
+		until, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+		if err != nil {
+			return 0, fmt.Errorf("can not read reservation: %s", path)
+		}
+		reserved[strings.TrimSuffix(filepath.Base(path), ".reserved")] = until
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Keep the verification cache of keys which have not changed.
+	for zone, entries := range keys {
+		for _, e := range entries {
+			for _, old := range s.keys[zone] {
+				if old.ID == e.ID && old.Encoded == e.Encoded {
+					e.verified = old.verified
+				}
+			}
+		}
+	}
+	s.keys = keys
+	s.reserved = reserved
+	s.fingerprint = fingerprint
+	return len(keys), nil
+}
+
+// readKeyFile reads the keys of a zone from path.
+func readKeyFile(path string) ([]*entry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = []byte(strings.TrimSpace(string(data)))
+	if len(data) == 0 {
+		return nil, fmt.Errorf("can not read key: %s", path)
+	}
+
+	if data[0] != '[' {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		return []*entry{{
+			Info: Info{
+				ID:      DefaultLabel,
+				Label:   DefaultLabel,
+				Created: fi.ModTime().UTC().Truncate(time.Second),
+			},
+			Encoded: string(data),
+		}}, nil
+	}
+
+	entries := []*entry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("can not read key: %s: %s", path, err)
+	}
+	return entries, nil
+}
+
+// Watch polls the key directory in the given interval and reloads all keys
+// whenever a key file was added, modified or removed. It never returns.
+func (s *Store) Watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		fingerprint, err := s.scan()
+		if err != nil {
+			log.Println("warning: can not scan key directory:", err)
+			continue
+		}
+
+		s.mu.RLock()
+		changed := fingerprint != s.fingerprint
+		s.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		n, err := s.Load()
+		if err != nil {
+			log.Println("warning: can not reload keys:", err)
+			continue
+		}
+		log.Printf("Reloaded %d keys.\n", n)
+	}
+}
+
+// scan returns a string which changes whenever a key or reservation file is
+// added, modified or removed.
+func (s *Store) scan() (string, error) {
+	keys, err := filepath.Glob(filepath.Join(s.dir, "*.key"))
+	if err != nil {
+		return "", err
+	}
+	reserved, err := filepath.Glob(filepath.Join(s.dir, "*.reserved"))
+	if err != nil {
+		return "", err
+	}
+	paths := append(keys, reserved...)
+	var b strings.Builder
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", path, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// Exists reports whether a key is stored for zone or its name is reserved.
+func (s *Store) Exists(zone string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.keys[zone]; ok {
+		return true
+	}
+	until, ok := s.reserved[zone]
+	return ok && time.Now().Before(until)
+}
+
+// Set hashes key and stores it as the only key of zone, on disk and in
+// memory. An expired reservation of the zone is dropped.
+func (s *Store) Set(zone string, key []byte) error {
+	e, err := newEntry(key, Info{Label: DefaultLabel})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e.Signing, err = s.seal(zone, e.ID, key); err != nil {
+		return err
+	}
+	if err := s.writeKeys(zone, []*entry{e}); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(s.dir, zone+".reserved")); err != nil && !os.IsNotExist(err) {
+		log.Printf("warning: can not remove reservation of zone %s: %s\n", zone, err)
+	}
+	delete(s.reserved, zone)
+	return nil
+}
+
+// Keys returns the valid keys of zone.
+func (s *Store) Keys(zone string) []Info {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	infos := []Info{}
+	for _, e := range s.keys[zone] {
+		if e.valid(now) {
+			infos = append(infos, e.Info)
+		}
+	}
+	return infos
+}
+
+// Key returns the valid key of zone with the given ID.
+func (s *Store) Key(zone, id string) (Info, bool) {
+	for _, info := range s.Keys(zone) {
+		if info.ID == id {
+			return info, true
+		}
+	}
+	return Info{}, false
+}
+
+// Add hashes key and stores it as an additional key of zone, with the label,
+// expiry and scope of info. The ID and creation time are assigned by Add.
+func (s *Store) Add(zone string, key []byte, info Info) (Info, error) {
+	e, err := newEntry(key, info)
+	if err != nil {
+		return Info{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e.Signing, err = s.seal(zone, e.ID, key); err != nil {
+		return Info{}, err
+	}
+	entries := append(s.validKeys(zone), e)
+	if err := s.writeKeys(zone, entries); err != nil {
+		return Info{}, err
+	}
+	return e.Info, nil
+}
+
+// Expire lets the key with the given ID expire at the given time, unless it
+// expires earlier anyway. The last permanent key with full access of a zone
+// can not be expired.
+func (s *Store) Expire(zone, id string, at time.Time) (Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.validKeys(zone)
+	for i, e := range entries {
+		if e.ID != id {
+			continue
+		}
+		if e.Expires != nil && !at.Before(*e.Expires) {
+			return e.Info, nil
+		}
+		// Entries are read without holding the lock, never modify them.
+		next := *e
+		at := at.UTC().Truncate(time.Second)
+		next.Expires = &at
+		entries[i] = &next
+		if e.Owner() && !hasOwner(entries) {
+			return Info{}, ErrLastKey
+		}
+		return next.Info, s.writeKeys(zone, entries)
+	}
+	return Info{}, ErrNotFound
+}
+
+// Revoke removes the key with the given ID. The last permanent key with full
+// access of a zone can not be revoked, use Delete instead.
+func (s *Store) Revoke(zone, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var revoked *entry
+	entries := []*entry{}
+	for _, e := range s.validKeys(zone) {
+		if e.ID == id {
+			revoked = e
+		} else {
+			entries = append(entries, e)
+		}
+	}
+	if revoked == nil {
+		return ErrNotFound
+	}
+	if len(entries) == 0 || revoked.Owner() && !hasOwner(entries) {
+		return ErrLastKey
+	}
+	return s.writeKeys(zone, entries)
+}
+
+// Delete revokes all keys of zone. If reserve is positive, the name remains
+// reserved for that long.
+func (s *Store) Delete(zone string, reserve time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if reserve > 0 {
+		until := time.Now().Add(reserve).UTC()
+		if err := s.write(zone+".reserved", until.Format(time.RFC3339)); err != nil {
+			return err
+		}
+		s.reserved[zone] = until
+	}
+
+	err := os.Remove(filepath.Join(s.dir, zone+".key"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(s.keys, zone)
+	return nil
+}
+
+// Verify reports whether key is a valid key of zone.
+func (s *Store) Verify(zone string, key []byte) bool {
+	_, ok := s.Identify(zone, key)
+	return ok
+}
+
+// Identify returns the info of the valid key of zone matching key. Outdated
+// stored keys are upgraded to the current hash format on the first successful
+// verification.
+func (s *Store) Identify(zone string, key []byte) (Info, bool) {
+	s.mu.RLock()
+	entries := s.keys[zone]
+	s.mu.RUnlock()
+
+	now := time.Now()
+	sum := sha256.Sum256(key)
+	for _, e := range entries {
+		if !e.valid(now) {
+			continue
+		}
+		s.mu.RLock()
+		v := e.verified
+		s.mu.RUnlock()
+		if v != nil && subtle.ConstantTimeCompare(v[:], sum[:]) == 1 {
+			return e.Info, true
+		}
+	}
+
+	for _, e := range entries {
+		if !e.valid(now) {
+			continue
+		}
+		ok, needsRehash := Verify(e.Encoded, key)
+		if !ok {
+			continue
+		}
+
+		s.mu.Lock()
+		e.verified = &sum
+		if needsRehash || e.Signing == "" && s.sealer != nil {
+			log.Printf("Upgrading key of zone: %s\n", zone)
+			if err := s.upgrade(zone, e, key, needsRehash); err != nil {
+				log.Printf("warning: can not upgrade key of zone %s: %s\n", zone, err)
+			}
+		}
+		s.mu.Unlock()
+		return e.Info, true
+	}
+	return Info{}, false
+}
+
+// VerifySignature returns the info of the valid key of zone which signed msg,
+// see Sign. If id is not empty, only the key with that ID is considered.
+func (s *Store) VerifySignature(zone, id, msg, signature string) (Info, bool) {
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return Info{}, false
+	}
+
+	s.mu.RLock()
+	entries := s.keys[zone]
+	s.mu.RUnlock()
+
+	now := time.Now()
+	for _, e := range entries {
+		if !e.valid(now) || e.Signing == "" || (id != "" && e.ID != id) {
+			continue
+		}
+		signingKey, ok := s.open(zone, e)
+		if !ok {
+			continue
+		}
+		expected, _ := hex.DecodeString(Sign(signingKey, msg))
+		if hmac.Equal(sig, expected) {
+			return e.Info, true
+		}
+	}
+	return Info{}, false
+}
+
+// upgrade replaces the stored form of e by a current hash of key, if rehash
+// is set, and adds the sealed signing key of key if it is missing. The caller
+// has to hold the lock.
+func (s *Store) upgrade(zone string, e *entry, key []byte, rehash bool) error {
+	next := *e
+	if rehash {
+		encoded, err := Hash(key)
+		if err != nil {
+			return err
+		}
+		next.Encoded = encoded
+	}
+	if next.Signing == "" {
+		signing, err := s.seal(zone, e.ID, key)
+		if err != nil {
+			return err
+		}
+		next.Signing = signing
+	}
+
+	entries := s.validKeys(zone)
+	for i, old := range entries {
+		if old == e {
+			entries[i] = &next
+		}
+	}
+	return s.writeKeys(zone, entries)
+}
+
+// validKeys returns the keys of zone which have not expired yet. The caller
+// has to hold the lock.
+func (s *Store) validKeys(zone string) []*entry {
+	now := time.Now()
+	entries := []*entry{}
+	for _, e := range s.keys[zone] {
+		if e.valid(now) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// writeKeys stores entries as the keys of zone, on disk and in memory. The
+// caller has to hold the lock.
+func (s *Store) writeKeys(zone string, entries []*entry) error {
+	data, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := s.write(zone+".key", string(data)+"\n"); err != nil {
+		return err
+	}
+	s.keys[zone] = entries
+	return nil
+}
+
+// newEntry hashes key for storage, as a key with the label, expiry and scope
+// of info.
+func newEntry(key []byte, info Info) (*entry, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	encoded, err := Hash(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	info.ID = hex.EncodeToString(id)
+	info.Created = time.Now().UTC().Truncate(time.Second)
+	return &entry{
+		Info:     info,
+		Encoded:  encoded,
+		verified: &sum,
+	}, nil
+}
+
+// seal returns the signing key of key, the key of zone with the given ID,
+// sealed for storage. It is empty without a signing secret. The caller has to
+// hold the lock.
+func (s *Store) seal(zone, id string, key []byte) (string, error) {
+	if s.sealer == nil {
+		return "", nil
+	}
+	nonce := make([]byte, s.sealer.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.sealer.Seal(nonce, nonce, SigningKey(key), []byte(zone+"/"+id))
+	return hex.EncodeToString(sealed), nil
+}
+
+// open returns the signing key of e, a key of zone, see seal.
+func (s *Store) open(zone string, e *entry) ([]byte, bool) {
+	s.mu.RLock()
+	sealer := s.sealer
+	s.mu.RUnlock()
+	if sealer == nil {
+		return nil, false
+	}
+
+	sealed, err := hex.DecodeString(e.Signing)
+	if err != nil || len(sealed) < sealer.NonceSize() {
+		return nil, false
+	}
+	n := sealer.NonceSize()
+	signingKey, err := sealer.Open(nil, sealed[:n], sealed[n:], []byte(zone+"/"+e.ID))
+	if err != nil {
+		return nil, false
+	}
+	return signingKey, true
+}
+
+// write atomically replaces the named file in the key directory.
+func (s *Store) write(name, content string) error {
+	tmp, err := ioutil.TempFile(s.dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+}
@@ -0,0 +1,355 @@
+package authkey
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSigningSecret is the signing secret of test stores.
+var testSigningSecret = []byte(strings.Repeat("s", secretSize))
+
+// newTestStore returns a store in a new temporary directory, which is removed
+// by the returned function.
+func newTestStore(t *testing.T) (*Store, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cion-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStore(dir)
+	if err := s.SetSigningSecret(testSigningSecret); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func TestStoreSetIdentify(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if s.Exists("example") {
+		t.Fatal("empty store has zone")
+	}
+	if err := s.Set("example", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Exists("example") {
+		t.Fatal("zone missing after Set")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, "example.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") || !strings.Contains(string(data), "$argon2id$") {
+		t.Fatalf("key not hashed at rest: %s", data)
+	}
+
+	tests := []struct {
+		zone string
+		key  string
+		ok   bool
+	}{
+		{"example", "secret", true},
+		{"example", "secret", true}, // cached
+		{"example", "Secret", false},
+		{"example", "", false},
+		{"other", "secret", false},
+	}
+	for _, tt := range tests {
+		info, ok := s.Identify(tt.zone, []byte(tt.key))
+		if ok != tt.ok {
+			t.Errorf("Identify(%s, %s) = %v, want %v", tt.zone, tt.key, ok, tt.ok)
+		}
+		if ok && info.Label != DefaultLabel {
+			t.Errorf("Identify(%s, %s): label %s, want %s", tt.zone, tt.key, info.Label, DefaultLabel)
+		}
+	}
+
+	// A second store reads the same keys.
+	other := NewStore(s.dir)
+	if n, err := other.Load(); err != nil || n != 1 {
+		t.Fatalf("Load = %d, %v", n, err)
+	}
+	if !other.Verify("example", []byte("secret")) {
+		t.Fatal("stored key not loaded")
+	}
+}
+
+func TestStoreLegacyKey(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	path := filepath.Join(s.dir, "example.key")
+	if err := ioutil.WriteFile(path, []byte("0123456789abcdef\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Verify("example", []byte("0123456789")) {
+		t.Fatal("wrong key accepted")
+	}
+	info, ok := s.Identify("example", []byte("0123456789abcdef"))
+	if !ok || info.ID != DefaultLabel {
+		t.Fatalf("Identify = %+v, %v", info, ok)
+	}
+
+	// The plain key is upgraded to a hash on its first use.
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "0123456789abcdef") {
+		t.Fatalf("legacy key not upgraded: %s", data)
+	}
+	if _, err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Verify("example", []byte("0123456789abcdef")) {
+		t.Fatal("upgraded key not accepted")
+	}
+
+	// It can sign requests since its first use.
+	msg := StringToSign("1700000000", "0123456789abcdef", "POST", "/zone/example", nil)
+	if _, ok := s.VerifySignature("example", "", msg, Sign(SigningKey([]byte("0123456789abcdef")), msg)); !ok {
+		t.Fatal("signature of upgraded key not accepted")
+	}
+
+	// So can a key stored without a signing secret.
+	if err := NewStore(s.dir).Set("other", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	sig := Sign(SigningKey([]byte("secret")), msg)
+	if _, ok := s.VerifySignature("other", "", msg, sig); ok {
+		t.Fatal("signature accepted before the first use of the key")
+	}
+	if _, ok := s.Identify("other", []byte("secret")); !ok {
+		t.Fatal("key not accepted")
+	}
+	if _, ok := s.VerifySignature("other", "", msg, sig); !ok {
+		t.Fatal("signature not accepted after the first use of the key")
+	}
+}
+
+func TestStoreKeys(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if err := s.Set("example", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := s.Identify("example", []byte("first"))
+	cam, err := s.Add("example", []byte("second"), Info{Label: "cam1", Scope: &Scope{Names: []string{"cam1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cam.ID == "" || cam.ID == first.ID || cam.Created.IsZero() {
+		t.Fatalf("unexpected info: %+v", cam)
+	}
+	if info, ok := s.Identify("example", []byte("second")); !ok || info.ID != cam.ID || info.Scope == nil {
+		t.Fatalf("Identify = %+v, %v", info, ok)
+	}
+	if n := len(s.Keys("example")); n != 2 {
+		t.Fatalf("%d keys, want 2", n)
+	}
+
+	// An expired key is no longer accepted.
+	if _, err := s.Expire("example", cam.ID, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if s.Verify("example", []byte("second")) {
+		t.Fatal("expired key accepted")
+	}
+	if _, ok := s.Key("example", cam.ID); ok {
+		t.Fatal("expired key listed")
+	}
+	if _, err := s.Expire("example", cam.ID, time.Now()); err != ErrNotFound {
+		t.Fatalf("Expire of expired key: got %v, want ErrNotFound", err)
+	}
+
+	// Expire never extends the lifetime of a key.
+	third, err := s.Add("example", []byte("third"), Info{Label: "ci"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	soon := time.Now().Add(time.Hour)
+	if _, err := s.Expire("example", third.ID, soon); err != nil {
+		t.Fatal(err)
+	}
+	info, err := s.Expire("example", third.ID, soon.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Expires == nil || info.Expires.After(soon) {
+		t.Fatalf("expiry extended to %v", info.Expires)
+	}
+
+	if err := s.Revoke("example", "unknown"); err != ErrNotFound {
+		t.Fatalf("Revoke unknown: got %v, want ErrNotFound", err)
+	}
+	if err := s.Revoke("example", third.ID); err != nil {
+		t.Fatal(err)
+	}
+	if s.Verify("example", []byte("third")) {
+		t.Fatal("revoked key accepted")
+	}
+	if err := s.Revoke("example", first.ID); err != ErrLastKey {
+		t.Fatalf("Revoke last key: got %v, want ErrLastKey", err)
+	}
+	if !s.Verify("example", []byte("first")) {
+		t.Fatal("last key revoked")
+	}
+}
+
+func TestStoreOwnerKey(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if err := s.Set("example", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := s.Identify("example", []byte("first"))
+	later := time.Now().Add(time.Hour)
+	if _, err := s.Add("example", []byte("scoped"), Info{Label: "cam1", Scope: &Scope{Names: []string{"cam1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	temporary, err := s.Add("example", []byte("temporary"), Info{Label: "ci", Expires: &later})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither a scoped nor an expiring key can take over the zone.
+	if err := s.Revoke("example", first.ID); err != ErrLastKey {
+		t.Fatalf("Revoke: got %v, want ErrLastKey", err)
+	}
+	if _, err := s.Expire("example", first.ID, later); err != ErrLastKey {
+		t.Fatalf("Expire: got %v, want ErrLastKey", err)
+	}
+	if info, ok := s.Key("example", first.ID); !ok || info.Expires != nil {
+		t.Fatalf("owner key changed to %+v, %v", info, ok)
+	}
+	if err := s.Revoke("example", temporary.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another owner key takes over.
+	second, err := s.Add("example", []byte("second"), Info{Label: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Expire("example", first.ID, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Revoke("example", second.ID); err != ErrLastKey {
+		t.Fatalf("Revoke: got %v, want ErrLastKey", err)
+	}
+	if err := s.Revoke("example", first.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	for _, zone := range []string{"kept", "reserved", "released"} {
+		if err := s.Set(zone, []byte(zone)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Delete("reserved", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("released", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reservation survives a reload.
+	if _, err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Exists("kept") || !s.Exists("reserved") || s.Exists("released") {
+		t.Fatal("unexpected zones after Delete")
+	}
+	if s.Verify("reserved", []byte("reserved")) {
+		t.Fatal("key of deleted zone accepted")
+	}
+
+	// Registering the name again drops the reservation.
+	if err := s.Set("reserved", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, "reserved.reserved")); !os.IsNotExist(err) {
+		t.Fatal("reservation not removed")
+	}
+}
+
+func TestStoreSignature(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if err := s.Set("example", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := s.Identify("example", []byte("secret"))
+	msg := StringToSign("1700000000", "0123456789abcdef", "POST", "/zone/example", nil)
+	sig := Sign(SigningKey([]byte("secret")), msg)
+
+	tests := []struct {
+		name string
+		zone string
+		id   string
+		msg  string
+		sig  string
+		ok   bool
+	}{
+		{"valid", "example", "", msg, sig, true},
+		{"key id", "example", info.ID, msg, sig, true},
+		{"other key id", "example", "other", msg, sig, false},
+		{"other zone", "other", "", msg, sig, false},
+		{"other message", "example", "", msg + "x", sig, false},
+		{"other key", "example", "", msg, Sign(SigningKey([]byte("Secret")), msg), false},
+		{"raw key", "example", "", msg, Sign([]byte("secret"), msg), false},
+		{"malformed", "example", "", msg, "xyz", false},
+	}
+	for _, tt := range tests {
+		if _, ok := s.VerifySignature(tt.zone, tt.id, tt.msg, tt.sig); ok != tt.ok {
+			t.Errorf("%s: VerifySignature = %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+
+	// The key file does not reveal the signing key.
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, "example.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), hex.EncodeToString(SigningKey([]byte("secret")))) {
+		t.Fatalf("signing key stored in the clear: %s", data)
+	}
+
+	// Nor can it be used without the signing secret.
+	for _, secret := range [][]byte{nil, []byte(strings.Repeat("o", secretSize))} {
+		other := NewStore(s.dir)
+		if secret != nil {
+			if err := other.SetSigningSecret(secret); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := other.Load(); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := other.VerifySignature("example", "", msg, sig); ok {
+			t.Errorf("signature accepted with signing secret %q", secret)
+		}
+	}
+}
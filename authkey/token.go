@@ -0,0 +1,94 @@
+package authkey
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ErrInvalidToken is returned for tokens which are malformed, expired or not
+// signed by us.
+var ErrInvalidToken = errors.New("authkey: invalid token")
+
+// Claims are the claims of access tokens. The subject is the zone, KeyID
+// refers to the key the token was issued for. A token is only valid as long
+// as that key is.
+type Claims struct {
+	jwt.StandardClaims
+	Zone  string `json:"zone"`
+	KeyID string `json:"kid"`
+	Scope *Scope `json:"scope,omitempty"`
+}
+
+// Tokens issues and verifies short-lived access tokens, signed JWTs which
+// can be used in place of a key.
+type Tokens struct {
+	secret []byte
+
+	// MaxTTL is the maximum lifetime of tokens.
+	MaxTTL time.Duration
+}
+
+// LoadTokens returns the token issuer signing with the secret in path. A new
+// secret is generated if the file does not exist yet.
+func LoadTokens(path string, maxTTL time.Duration) (*Tokens, error) {
+	secret, err := LoadSecret(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Tokens{secret: secret, MaxTTL: maxTTL}, nil
+}
+
+// Issue returns a token for key of zone, restricted to scope and expiring
+// after ttl, or MaxTTL if that is shorter.
+func (t *Tokens) Issue(zone string, key Info, scope *Scope, ttl time.Duration) (string, time.Time, error) {
+	if ttl <= 0 || ttl > t.MaxTTL {
+		ttl = t.MaxTTL
+	}
+	// The token must not outlive its key.
+	now := time.Now().UTC().Truncate(time.Second)
+	expires := now.Add(ttl)
+	if key.Expires != nil && key.Expires.Before(expires) {
+		expires = *key.Expires
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", time.Time{}, err
+	}
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        hex.EncodeToString(id),
+			Subject:   zone,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: expires.Unix(),
+		},
+		Zone:  zone,
+		KeyID: key.ID,
+		Scope: scope,
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(t.secret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// Parse verifies token and returns its claims.
+func (t *Tokens) Parse(token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, ErrInvalidToken
+		}
+		return t.secret, nil
+	})
+	if err != nil || claims.Zone == "" || claims.Zone != claims.Subject || claims.ExpiresAt == 0 {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
@@ -0,0 +1,96 @@
+package authkey
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cion-tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token.secret")
+	tokens, err := LoadTokens(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The generated secret is kept.
+	again, err := LoadTokens(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scope := &Scope{Types: []string{"TXT"}}
+	token, expires, err := tokens.Issue("example", Info{ID: "k1"}, scope, 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expires); d > time.Hour || d < 59*time.Minute {
+		t.Errorf("token expires in %s, want MaxTTL", d)
+	}
+
+	claims, err := again.Parse(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Zone != "example" || claims.KeyID != "k1" || !claims.Scope.Allows("TXT", "@") || claims.Scope.Allows("A", "@") {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	// A token does not outlive its key.
+	keyExpires := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+	_, expires, err = tokens.Issue("example", Info{ID: "k1", Expires: &keyExpires}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expires.Equal(keyExpires) {
+		t.Errorf("token expires %s, want %s", expires, keyExpires)
+	}
+
+	other, err := LoadTokens(filepath.Join(dir, "other.secret"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		StandardClaims: jwt.StandardClaims{Subject: "example", ExpiresAt: time.Now().Add(-time.Minute).Unix()},
+		Zone:           "example",
+	}).SignedString(tokens.secret)
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, Claims{
+		StandardClaims: jwt.StandardClaims{Subject: "example", ExpiresAt: time.Now().Add(time.Minute).Unix()},
+		Zone:           "example",
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	mismatch, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		StandardClaims: jwt.StandardClaims{Subject: "other", ExpiresAt: time.Now().Add(time.Minute).Unix()},
+		Zone:           "example",
+	}).SignedString(tokens.secret)
+	unlimited, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		StandardClaims: jwt.StandardClaims{Subject: "example"},
+		Zone:           "example",
+	}).SignedString(tokens.secret)
+
+	invalid := map[string]string{
+		"foreign secret": token,
+		"expired":        expired,
+		"unsigned":       unsigned,
+		"subject":        mismatch,
+		"no expiry":      unlimited,
+		"malformed":      strings.Replace(token, ".", "", 1),
+	}
+	for name, token := range invalid {
+		parser := tokens
+		if name == "foreign secret" {
+			parser = other
+		}
+		if _, err := parser.Parse(token); err != ErrInvalidToken {
+			t.Errorf("%s: got %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Package backend defines the record store all zone modifications are
+// applied to.
+package backend
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// ErrConflict is returned by Replace if the RRsets have been modified
+	// since they were read.
+	ErrConflict = errors.New("backend: records have been modified concurrently")
+
+	// ErrOutOfZone is returned if a record does not belong to the zone it is
+	// applied to.
+	ErrOutOfZone = errors.New("backend: record is out of zone")
+)
+
+// Backend stores the records of all zones.
+//
+// Zones and record names are always fully qualified. A zone contains all
+// records at or below its name.
+type Backend interface {
+	// List returns all records of the zone.
+	List(zone string) ([]dns.RR, error)
+
+	// Lookup returns the RRset of the given owner name and type. A
+	// non-existing RRset results in an empty slice.
+	Lookup(zone, name string, rrtype uint16) ([]dns.RR, error)
+
+	// Create adds the records to their RRsets.
+	Create(zone string, rrs ...dns.RR) error
+
+	// Replace atomically replaces the RRsets in old with the records in rrs.
+	// The old records have to contain the complete RRsets as they were
+	// returned by Lookup, if any of them has changed meanwhile ErrConflict is
+	// returned and nothing is modified.
+	Replace(zone string, old, rrs []dns.RR) error
+
+	// Delete removes the records from their RRsets.
+	Delete(zone string, rrs ...dns.RR) error
+}
+
+// inZone returns ErrOutOfZone unless all records are at or below zone.
+func inZone(zone string, rrs ...[]dns.RR) error {
+	for _, list := range rrs {
+		for _, rr := range list {
+			if !dns.IsSubDomain(zone, rr.Header().Name) {
+				return ErrOutOfZone
+			}
+		}
+	}
+	return nil
+}
+
+// Sort orders records by owner name and type, keeping the order of records
+// within the same RRset. Names are compared label by label from the right, so
+// that every name is directly followed by the names below it.
+func Sort(rrs []dns.RR) {
+	sort.SliceStable(rrs, func(i, j int) bool {
+		a, b := rrs[i].Header(), rrs[j].Header()
+		la := dns.SplitDomainName(dns.CanonicalName(a.Name))
+		lb := dns.SplitDomainName(dns.CanonicalName(b.Name))
+		for x, y := len(la)-1, len(lb)-1; x >= 0 && y >= 0; x, y = x-1, y-1 {
+			if la[x] != lb[y] {
+				return la[x] < lb[y]
+			}
+		}
+		if len(la) != len(lb) {
+			return len(la) < len(lb)
+		}
+		return a.Rrtype < b.Rrtype
+	})
+}
+
+// Equal reports whether a and b contain the same records, regardless of their
+// order and TTL.
+func Equal(a, b []dns.RR) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if dns.IsDuplicate(x, y) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package backend
+
+import (
+	"github.com/baccenfutter/cion/nsupdate"
+	"github.com/miekg/dns"
+)
+
+// Bind is a Backend which applies all changes to a nameserver via signed
+// RFC 2136 DNS UPDATE messages and reads records via queries and AXFR.
+type Bind struct {
+	client *nsupdate.Client
+}
+
+// NewBind returns a Bind backend for the zone served by server.
+func NewBind(server, zone string, key *nsupdate.Key) *Bind {
+	return &Bind{
+		client: nsupdate.NewClient(server, zone, key),
+	}
+}
+
+// List implements Backend.
+func (b *Bind) List(zone string) ([]dns.RR, error) {
+	all, err := b.client.Transfer()
+	if err != nil {
+		return nil, err
+	}
+
+	rrs := []dns.RR{}
+	for _, rr := range all {
+		if dns.IsSubDomain(zone, rr.Header().Name) {
+			rrs = append(rrs, rr)
+		}
+	}
+	Sort(rrs)
+	return rrs, nil
+}
+
+// Lookup implements Backend.
+func (b *Bind) Lookup(zone, name string, rrtype uint16) ([]dns.RR, error) {
+	if !dns.IsSubDomain(zone, name) {
+		return nil, ErrOutOfZone
+	}
+	return b.client.Lookup(name, rrtype)
+}
+
+// Create implements Backend.
+func (b *Bind) Create(zone string, rrs ...dns.RR) error {
+	if err := inZone(zone, rrs); err != nil {
+		return err
+	}
+	return b.client.Add(rrs...)
+}
+
+// Replace implements Backend.
+func (b *Bind) Replace(zone string, old, rrs []dns.RR) error {
+	if err := inZone(zone, old, rrs); err != nil {
+		return err
+	}
+	err := b.client.Replace(old, rrs)
+	if err == nsupdate.ErrConflict {
+		return ErrConflict
+	}
+	return err
+}
+
+// Delete implements Backend.
+func (b *Bind) Delete(zone string, rrs ...dns.RR) error {
+	if err := inZone(zone, rrs); err != nil {
+		return err
+	}
+	return b.client.Remove(rrs...)
+}
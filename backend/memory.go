@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// Memory is a Backend which keeps all records in memory. It is meant for
+// development and testing, all records are lost on exit.
+type Memory struct {
+	mu     sync.RWMutex
+	rrsets map[string][]dns.RR
+}
+
+// NewMemory returns an empty Memory backend.
+func NewMemory() *Memory {
+	return &Memory{
+		rrsets: map[string][]dns.RR{},
+	}
+}
+
+// rrsetKey returns the map key of the RRset rr belongs to.
+func rrsetKey(rr dns.RR) string {
+	hdr := rr.Header()
+	return dns.CanonicalName(hdr.Name) + "/" + dns.TypeToString[hdr.Rrtype]
+}
+
+// List implements Backend.
+func (m *Memory) List(zone string) ([]dns.RR, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rrs := []dns.RR{}
+	for _, rrset := range m.rrsets {
+		for _, rr := range rrset {
+			if dns.IsSubDomain(zone, rr.Header().Name) {
+				rrs = append(rrs, dns.Copy(rr))
+			}
+		}
+	}
+	Sort(rrs)
+	return rrs, nil
+}
+
+// Lookup implements Backend.
+func (m *Memory) Lookup(zone, name string, rrtype uint16) ([]dns.RR, error) {
+	if !dns.IsSubDomain(zone, name) {
+		return nil, ErrOutOfZone
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key := rrsetKey(&dns.ANY{Hdr: dns.RR_Header{Name: name, Rrtype: rrtype}})
+	rrs := []dns.RR{}
+	for _, rr := range m.rrsets[key] {
+		rrs = append(rrs, dns.Copy(rr))
+	}
+	return rrs, nil
+}
+
+// Create implements Backend.
+func (m *Memory) Create(zone string, rrs ...dns.RR) error {
+	if err := inZone(zone, rrs); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, rr := range rrs {
+		m.add(rr)
+	}
+	return nil
+}
+
+// Replace implements Backend.
+func (m *Memory) Replace(zone string, old, rrs []dns.RR) error {
+	if err := inZone(zone, old, rrs); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Group the expected state by RRset, every RRset touched by rrs is
+	// expected to be empty unless it is part of old.
+	expected := map[string][]dns.RR{}
+	for _, rr := range rrs {
+		expected[rrsetKey(rr)] = nil
+	}
+	for _, rr := range old {
+		expected[rrsetKey(rr)] = append(expected[rrsetKey(rr)], rr)
+	}
+	for key, want := range expected {
+		if !Equal(m.rrsets[key], want) {
+			return ErrConflict
+		}
+	}
+
+	for key := range expected {
+		delete(m.rrsets, key)
+	}
+	for _, rr := range rrs {
+		m.add(rr)
+	}
+	return nil
+}
+
+// Delete implements Backend.
+func (m *Memory) Delete(zone string, rrs ...dns.RR) error {
+	if err := inZone(zone, rrs); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, rr := range rrs {
+		key := rrsetKey(rr)
+		rrset := []dns.RR{}
+		for _, cur := range m.rrsets[key] {
+			if !dns.IsDuplicate(cur, rr) {
+				rrset = append(rrset, cur)
+			}
+		}
+		if len(rrset) == 0 {
+			delete(m.rrsets, key)
+		} else {
+			m.rrsets[key] = rrset
+		}
+	}
+	return nil
+}
+
+// add adds rr to its RRset unless it is already present. Like a nameserver
+// all records of an RRset share the TTL of the most recently added one.
+func (m *Memory) add(rr dns.RR) {
+	key := rrsetKey(rr)
+	rr = dns.Copy(rr)
+	rrset := []dns.RR{}
+	for _, cur := range m.rrsets[key] {
+		if !dns.IsDuplicate(cur, rr) {
+			cur.Header().Ttl = rr.Header().Ttl
+			rrset = append(rrset, cur)
+		}
+	}
+	m.rrsets[key] = append(rrset, rr)
+}
@@ -0,0 +1,266 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const zone = "example.cion.test."
+
+// rr parses a record in zone file format, failing the test on errors.
+func rr(t *testing.T, s string) dns.RR {
+	t.Helper()
+	r, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("can not parse %q: %s", s, err)
+	}
+	return r
+}
+
+// rrs parses several records, see rr.
+func rrs(t *testing.T, ss ...string) []dns.RR {
+	t.Helper()
+	list := []dns.RR{}
+	for _, s := range ss {
+		list = append(list, rr(t, s))
+	}
+	return list
+}
+
+// assertRRset fails the test unless the RRset of name and type in b holds
+// exactly the records want, with their TTLs.
+func assertRRset(t *testing.T, b Backend, name string, rrtype uint16, want []dns.RR) {
+	t.Helper()
+	got, err := b.Lookup(zone, name, rrtype)
+	if err != nil {
+		t.Fatalf("Lookup(%s, %s): %s", name, dns.TypeToString[rrtype], err)
+	}
+	if !Equal(got, want) {
+		t.Fatalf("Lookup(%s, %s) = %v, want %v", name, dns.TypeToString[rrtype], got, want)
+	}
+	for _, x := range got {
+		for _, y := range want {
+			if dns.IsDuplicate(x, y) && x.Header().Ttl != y.Header().Ttl {
+				t.Fatalf("Lookup(%s, %s): TTL of %s is %d, want %d",
+					name, dns.TypeToString[rrtype], x, x.Header().Ttl, y.Header().Ttl)
+			}
+		}
+	}
+}
+
+func TestMemoryCreate(t *testing.T) {
+	b := NewMemory()
+	if err := b.Create(zone, rrs(t,
+		"www.example.cion.test. 180 IN A 192.0.2.1",
+		"www.example.cion.test. 180 IN A 192.0.2.2",
+		"WWW.example.cion.test. 180 IN A 192.0.2.1",
+	)...); err != nil {
+		t.Fatal(err)
+	}
+	assertRRset(t, b, "www.example.cion.test.", dns.TypeA, rrs(t,
+		"www.example.cion.test. 180 IN A 192.0.2.1",
+		"www.example.cion.test. 180 IN A 192.0.2.2",
+	))
+
+	// Like a nameserver, the RRset takes the TTL of the latest record.
+	if err := b.Create(zone, rr(t, "www.example.cion.test. 60 IN A 192.0.2.3")); err != nil {
+		t.Fatal(err)
+	}
+	assertRRset(t, b, "www.example.cion.test.", dns.TypeA, rrs(t,
+		"www.example.cion.test. 60 IN A 192.0.2.1",
+		"www.example.cion.test. 60 IN A 192.0.2.2",
+		"www.example.cion.test. 60 IN A 192.0.2.3",
+	))
+}
+
+func TestMemoryOutOfZone(t *testing.T) {
+	b := NewMemory()
+	outside := rr(t, "www.other.cion.test. 180 IN A 192.0.2.1")
+
+	if err := b.Create(zone, outside); err != ErrOutOfZone {
+		t.Errorf("Create: got %v, want ErrOutOfZone", err)
+	}
+	if err := b.Replace(zone, nil, []dns.RR{outside}); err != ErrOutOfZone {
+		t.Errorf("Replace: got %v, want ErrOutOfZone", err)
+	}
+	if err := b.Delete(zone, outside); err != ErrOutOfZone {
+		t.Errorf("Delete: got %v, want ErrOutOfZone", err)
+	}
+	if _, err := b.Lookup(zone, "www.other.cion.test.", dns.TypeA); err != ErrOutOfZone {
+		t.Errorf("Lookup: got %v, want ErrOutOfZone", err)
+	}
+}
+
+func TestMemoryReplace(t *testing.T) {
+	initial := []string{
+		"www.example.cion.test. 180 IN A 192.0.2.1",
+		"www.example.cion.test. 180 IN A 192.0.2.2",
+		"example.cion.test. 180 IN MX 10 mail.example.cion.test.",
+	}
+
+	tests := []struct {
+		name    string
+		old     []string
+		rrs     []string
+		wantErr error
+		// want is the resulting A RRset of www.
+		want []string
+	}{
+		{
+			name: "replace rrset",
+			old:  initial[:2],
+			rrs:  []string{"www.example.cion.test. 60 IN A 192.0.2.3"},
+			want: []string{"www.example.cion.test. 60 IN A 192.0.2.3"},
+		},
+		{
+			name: "old ignores ttl",
+			old:  []string{"www.example.cion.test. 3600 IN A 192.0.2.1", "www.example.cion.test. 3600 IN A 192.0.2.2"},
+			rrs:  []string{"www.example.cion.test. 180 IN A 192.0.2.1"},
+			want: []string{"www.example.cion.test. 180 IN A 192.0.2.1"},
+		},
+		{
+			name: "delete rrset",
+			old:  initial[:2],
+			want: []string{},
+		},
+		{
+			name:    "incomplete old",
+			old:     initial[:1],
+			rrs:     []string{"www.example.cion.test. 180 IN A 192.0.2.3"},
+			wantErr: ErrConflict,
+			want:    initial[:2],
+		},
+		{
+			name:    "modified old",
+			old:     []string{"www.example.cion.test. 180 IN A 192.0.2.1", "www.example.cion.test. 180 IN A 192.0.2.9"},
+			rrs:     []string{"www.example.cion.test. 180 IN A 192.0.2.3"},
+			wantErr: ErrConflict,
+			want:    initial[:2],
+		},
+		{
+			name:    "existing rrset not in old",
+			rrs:     []string{"www.example.cion.test. 180 IN A 192.0.2.3"},
+			wantErr: ErrConflict,
+			want:    initial[:2],
+		},
+		{
+			name: "several rrsets",
+			old:  initial,
+			rrs: []string{
+				"www.example.cion.test. 180 IN A 192.0.2.3",
+				"mail.example.cion.test. 180 IN A 192.0.2.4",
+			},
+			want: []string{"www.example.cion.test. 180 IN A 192.0.2.3"},
+		},
+		{
+			name: "conflict in one of several rrsets",
+			old:  initial[:2],
+			rrs: []string{
+				"www.example.cion.test. 180 IN A 192.0.2.3",
+				"example.cion.test. 180 IN MX 20 mail.example.cion.test.",
+			},
+			wantErr: ErrConflict,
+			want:    initial[:2],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMemory()
+			if err := b.Create(zone, rrs(t, initial...)...); err != nil {
+				t.Fatal(err)
+			}
+
+			err := b.Replace(zone, rrs(t, tt.old...), rrs(t, tt.rrs...))
+			if err != tt.wantErr {
+				t.Fatalf("Replace: got %v, want %v", err, tt.wantErr)
+			}
+			assertRRset(t, b, "www.example.cion.test.", dns.TypeA, rrs(t, tt.want...))
+		})
+	}
+}
+
+func TestMemoryDelete(t *testing.T) {
+	b := NewMemory()
+	if err := b.Create(zone, rrs(t,
+		"www.example.cion.test. 180 IN A 192.0.2.1",
+		"www.example.cion.test. 180 IN A 192.0.2.2",
+	)...); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Delete(zone, rr(t, "www.example.cion.test. 60 IN A 192.0.2.1")); err != nil {
+		t.Fatal(err)
+	}
+	assertRRset(t, b, "www.example.cion.test.", dns.TypeA, rrs(t, "www.example.cion.test. 180 IN A 192.0.2.2"))
+
+	// Deleting a missing record is not an error.
+	if err := b.Delete(zone, rr(t, "www.example.cion.test. 180 IN A 192.0.2.9")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(zone, rr(t, "www.example.cion.test. 180 IN A 192.0.2.2")); err != nil {
+		t.Fatal(err)
+	}
+	assertRRset(t, b, "www.example.cion.test.", dns.TypeA, nil)
+}
+
+func TestMemoryList(t *testing.T) {
+	b := NewMemory()
+	if err := b.Create("cion.test.", rrs(t,
+		"www.example.cion.test. 180 IN A 192.0.2.1",
+		"example.cion.test. 180 IN TXT \"apex\"",
+		"a.www.example.cion.test. 180 IN A 192.0.2.2",
+		"example.cion.test. 180 IN A 192.0.2.3",
+		"other.cion.test. 180 IN A 192.0.2.4",
+		"example2.cion.test. 180 IN A 192.0.2.5",
+	)...); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := b.List(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := rrs(t,
+		"example.cion.test. 180 IN A 192.0.2.3",
+		"example.cion.test. 180 IN TXT \"apex\"",
+		"www.example.cion.test. 180 IN A 192.0.2.1",
+		"a.www.example.cion.test. 180 IN A 192.0.2.2",
+	)
+	if len(got) != len(want) {
+		t.Fatalf("List = %v, want %v", got, want)
+	}
+	for i := range got {
+		if !dns.IsDuplicate(got[i], want[i]) {
+			t.Fatalf("List = %v, want %v", got, want)
+		}
+	}
+
+	// The records returned are copies.
+	got[0].Header().Ttl = 1
+	assertRRset(t, b, "example.cion.test.", dns.TypeA, rrs(t, "example.cion.test. 180 IN A 192.0.2.3"))
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want bool
+	}{
+		{nil, nil, true},
+		{[]string{"a.cion.test. 60 IN A 192.0.2.1"}, []string{"a.cion.test. 180 IN A 192.0.2.1"}, true},
+		{[]string{"a.cion.test. 60 IN A 192.0.2.1"}, []string{"A.cion.test. 60 IN A 192.0.2.1"}, true},
+		{[]string{"a.cion.test. 60 IN A 192.0.2.1"}, []string{"a.cion.test. 60 IN A 192.0.2.2"}, false},
+		{[]string{"a.cion.test. 60 IN A 192.0.2.1"}, nil, false},
+		{
+			[]string{"a.cion.test. 60 IN A 192.0.2.1", "a.cion.test. 60 IN A 192.0.2.2"},
+			[]string{"a.cion.test. 60 IN A 192.0.2.2", "a.cion.test. 60 IN A 192.0.2.1"},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		if got := Equal(rrs(t, tt.a...), rrs(t, tt.b...)); got != tt.want {
+			t.Errorf("Equal(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -1,19 +1,46 @@
 package cmd
 
 import (
+	"log"
+
 	"github.com/baccenfutter/cion/api"
+	"github.com/baccenfutter/cion/backend"
+	"github.com/baccenfutter/cion/config"
+	"github.com/baccenfutter/cion/nsupdate"
 	"github.com/spf13/cobra"
 )
 
+var backendName string
+
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Start API backend and serve all requests.",
 	Run: func(cmd *cobra.Command, args []string) {
 		api.LoadKeys()
-		api.ListenAndServe()
+		api.ListenAndServe(newBackend())
 	},
 }
 
 func init() {
+	serveCmd.Flags().StringVar(&backendName, "backend", "bind",
+		"record store to use: bind or memory (development only, records are lost on exit)")
 	rootCmd.AddCommand(serveCmd)
 }
+
+// newBackend returns the record backend selected via --backend.
+func newBackend() backend.Backend {
+	switch backendName {
+	case "bind":
+		cfg := config.Config()
+		key, err := nsupdate.LoadKey(cfg.RNDCKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return backend.NewBind(cfg.NameServer, cfg.RootDomain, key)
+	case "memory":
+		log.Println("warning: using in-memory backend, records are lost on exit")
+		return backend.NewMemory()
+	}
+	log.Fatalf("unknown backend: %s", backendName)
+	return nil
+}
@@ -2,6 +2,10 @@ package config
 
 import (
 	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
@@ -12,20 +16,140 @@ type Specification struct {
 	ConfDir    string `envconfig:"conf_dir"`
 	ZoneDir    string `envconfig:"zone_dir"`
 	RootDomain string `required:"true" envconfig:"root_domain"`
-	TTL        uint
+	// NS1Hostname and NS2Hostname are the names of the nameservers below the
+	// root domain. They, the names in ReservedZones and those of TLSHosts
+	// can not be registered as zones.
+	NS1Hostname   string   `envconfig:"ns1_hostname"`
+	NS2Hostname   string   `envconfig:"ns2_hostname"`
+	ReservedZones []string `envconfig:"reserved_zones"`
+	// TTL is the default TTL of records, clients may request any TTL from
+	// MinTTL to MaxTTL instead.
+	TTL    uint
+	MinTTL uint `envconfig:"min_ttl"`
+	MaxTTL uint `envconfig:"max_ttl"`
+	// NameServer is the address DNS updates are sent to.
+	NameServer string `envconfig:"name_server"`
+	// RNDCKeyFile holds the TSIG key DNS updates are signed with.
+	RNDCKeyFile string `envconfig:"rndc_key_file"`
+	// ZoneKeysFile is generated from the per-zone TSIG keys and included by
+	// named.conf.
+	ZoneKeysFile string `envconfig:"zone_keys_file"`
+	// KeyRefresh is the interval in which KeyDir is checked for changes.
+	KeyRefresh time.Duration `envconfig:"key_refresh"`
+	// TrustedProxies lists the addresses and networks of reverse proxies
+	// whose forwarding headers reveal the address of the client.
+	TrustedProxies []string `envconfig:"trusted_proxies"`
+	// ZoneCooldown is the period the name of a deleted zone remains
+	// unavailable for registration.
+	ZoneCooldown time.Duration `envconfig:"zone_cooldown"`
+	// SecretDir holds the secrets of the server. It must not be KeyDir or
+	// within it, so that they are not exposed along with the keys.
+	SecretDir string `envconfig:"secret_dir"`
+	// TokenSecretFile holds the secret access tokens are signed with, it is
+	// generated if missing. It defaults to token.secret in SecretDir.
+	TokenSecretFile string `envconfig:"token_secret_file"`
+	// SigningSecretFile holds the secret the signing keys of the auth keys
+	// are sealed with, it is generated if missing. Without it, no request
+	// signed with one of the stored keys is accepted any more. It defaults
+	// to signing.secret in SecretDir.
+	SigningSecretFile string `envconfig:"signing_secret_file"`
+	// TokenTTL is the maximum lifetime of access tokens.
+	TokenTTL time.Duration `envconfig:"token_ttl"`
+	// SignatureSkew is the maximum clock skew accepted for signed requests.
+	SignatureSkew time.Duration `envconfig:"signature_skew"`
+
+	// Listen is the address of the HTTP server. If TLS is configured, it
+	// redirects to TLSListen, unless HTTPRedirect is disabled.
+	Listen       string `envconfig:"listen"`
+	TLSListen    string `envconfig:"tls_listen"`
+	HTTPRedirect bool   `envconfig:"http_redirect"`
+	// TLSCertFile and TLSKeyFile hold a static certificate for the HTTPS
+	// server. Like all secrets, the key must not be stored in KeyDir.
+	TLSCertFile string `envconfig:"tls_cert_file"`
+	TLSKeyFile  string `envconfig:"tls_key_file"`
+	// AutoCert enables certificates obtained via ACME for the root domain and
+	// TLSHosts. With AutoCertDNS a wildcard certificate for the root domain
+	// is obtained via DNS-01 challenges, published in our own zone.
+	AutoCert    bool     `envconfig:"autocert"`
+	AutoCertDNS bool     `envconfig:"autocert_dns"`
+	TLSHosts    []string `envconfig:"tls_hosts"`
+	// ACMEDirectory is the directory URL of the ACME CA, ACMEEmail the
+	// contact address of the account.
+	ACMEDirectory string `envconfig:"acme_directory"`
+	ACMEEmail     string `envconfig:"acme_email"`
+	// CertDir caches the ACME account and the certificates, along with their
+	// private keys. It defaults to certs in SecretDir.
+	CertDir string `envconfig:"cert_dir"`
 }
 
-// Config reads and returns the configuration from the environment
+var (
+	loadOnce sync.Once
+	loaded   *Specification
+)
+
+// Config returns the configuration. It is read from the environment on the
+// first call only, callers must not modify it.
 func Config() *Specification {
+	loadOnce.Do(func() {
+		loaded = load()
+	})
+	return loaded
+}
+
+// load reads the configuration from the environment.
+func load() *Specification {
 	s := &Specification{
 		KeyDir:  "/etc/bind/keys",
 		ConfDir: "/etc/bind/zones",
 		ZoneDir: "/var/bind/dyn",
 		TTL:     180,
+		MinTTL:  60,
+		MaxTTL:  86400,
+
+		NS1Hostname: "ns1",
+		NS2Hostname: "ns2",
+		ReservedZones: []string{
+			"www", "ns", "mail", "smtp", "imap", "pop", "api",
+			"hostmaster", "postmaster", "webmaster", "admin",
+			"autoconfig", "autodiscover",
+		},
+
+		NameServer:    "127.0.0.1:53",
+		RNDCKeyFile:   "/etc/bind/named.conf.rndc",
+		SecretDir:     "/etc/cion",
+		ZoneKeysFile:  "/etc/bind/named.conf.zonekeys",
+		KeyRefresh:    10 * time.Second,
+		TokenTTL:      time.Hour,
+		SignatureSkew: 5 * time.Minute,
+
+		Listen:        ":80",
+		TLSListen:     ":443",
+		HTTPRedirect:  true,
+		ACMEDirectory: "https://acme-v02.api.letsencrypt.org/directory",
 	}
 	err := envconfig.Process("cion", s)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if s.TokenSecretFile == "" {
+		s.TokenSecretFile = filepath.Join(s.SecretDir, "token.secret")
+	}
+	if s.SigningSecretFile == "" {
+		s.SigningSecretFile = filepath.Join(s.SecretDir, "signing.secret")
+	}
+	if s.CertDir == "" {
+		s.CertDir = filepath.Join(s.SecretDir, "certs")
+	}
+	for _, path := range []string{s.SecretDir, s.TokenSecretFile, s.SigningSecretFile, s.CertDir, s.TLSKeyFile} {
+		if path != "" && within(s.KeyDir, path) {
+			log.Fatalf("secrets must not be stored in the key directory: %s", path)
+		}
+	}
 	return s
 }
+
+// within reports whether path is dir or lies within it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -14,6 +14,8 @@ Commands
         register [-w] zonename
         update zonename item value
         deletezone zonename
+Environment
+        CION_AUTH_KEY   auth-key of the zone, required by deletezone
 EOFHELP
 }
 
@@ -23,6 +25,11 @@ ${0##*/} version 0.0.1
 EOFVERSION
 }
 
+# print the string value of a top-level field of a JSON response
+json_field() {
+    echo "$2" | sed -n -e "s/.*\"$1\":\"\([^\"]*\)\".*/\1/p"
+}
+
 # register
 register_namespace() {
 # store the whole response with the status at the and
@@ -47,14 +54,14 @@ HTTP_STATUS=$(echo "$HTTP_RESPONSE" | tail -n 1)
 # example using the status
 case "$HTTP_STATUS" in
     202)    #echo "Domain created. Access token:"
-            TOKEN=$(echo "$HTTP_BODY" | sed -e 's/[":,{}]//g' -e 's/\([a-z ]*\)auth_key//')
+            TOKEN=$(json_field auth_key "$HTTP_BODY")
             echo ${TOKEN}
             exit 0
             ;;
     423)    echo "Domain already taken."
             exit 11
             ;;
-    429)    TIME=$(echo "$HTTP_BODY" | sed -e 's/[":{}]//g' -e 's/\([a-z ]*\)\([0-9]\{1,2\}h[0-9]\{1,2\}m[0-9]\{1,2\}s\)/\2/' -e 's/[mh]/:/g' -e 's/s//')
+    429)    TIME=$(json_field message "$HTTP_BODY" | sed -e 's/.* in //' -e 's/[mh]/:/g' -e 's/s//')
             
             
             echo "Time to wait for next registration: ${TIME}"
@@ -66,13 +73,47 @@ case "$HTTP_STATUS" in
 
             exit 12
             ;;
-    *)  echo "wut? $HTTP_STATUS"
+    *)  echo "[ERROR] $(json_field code "$HTTP_BODY"): $(json_field message "$HTTP_BODY")"
         exit 13
         ;;
 esac
     
 }
 
+# deletezone
+delete_zone() {
+if [[ -z ${CION_AUTH_KEY} ]]; then
+    echo "[ERROR] No auth-key given, please set CION_AUTH_KEY."
+    exit 6
+fi
+
+# store the whole response with the status at the and
+HTTP_RESPONSE=$(curl -s \
+    -w '\n%{http_code}' \
+    -X DELETE \
+    -H "Accept: application/json; version=1.0.0" \
+    -H "X-Cion-Auth-Key: ${CION_AUTH_KEY}" \
+    ${CION_WEB_URL}/zone/${1})
+
+# extract the body
+HTTP_BODY=$(echo "$HTTP_RESPONSE" | sed \$d)
+
+# extract the status
+HTTP_STATUS=$(echo "$HTTP_RESPONSE" | tail -n 1)
+
+case "$HTTP_STATUS" in
+    202)    echo "Domain deleted."
+            exit 0
+            ;;
+    401)    echo "Authentication failed."
+            exit 14
+            ;;
+    *)  echo "[ERROR] $(json_field code "$HTTP_BODY"): $(json_field message "$HTTP_BODY")"
+        exit 13
+        ;;
+esac
+}
+
 ##################################
 # Commandline processing
 ##################################
@@ -140,6 +181,19 @@ case ${CMD} in
         fi
         register_namespace ${1} ${OPT_REGWAIT}
         ;;
+    deletezone )
+        if [[ $# -gt 1 ]]; then
+            echo "[ERROR] Extra arguments given."
+            show_help
+            exit 4
+        fi
+        if [[ $# -eq 0 ]]; then
+            echo "[ERROR] No domain name given."
+            show_help
+            exit 5
+        fi
+        delete_zone ${1}
+        ;;
 
     *)  echo "ERROR: Unrecognized commnd '${CMD}'"
         show_help
@@ -26,6 +26,14 @@ CION_NS1_ADDRESS="${CION_NS1_ADDRESS:-127.0.0.1}"
 CION_NS2_ADDRESS="${CION_NS2_ADDRESS:-127.0.0.1}"
 CION_TTL="${CION_TTL:-180}"
 
+#
+# Per-zone TSIG keys are named like their zone. "selfwild" restricts each of
+# them to the names below it, the zone apex and the delegation types NS and
+# DS are reserved to the API.
+#
+CION_TSIG_TYPES="A AAAA MX SRV TXT CNAME CAA SSHFP TLSA SVCB HTTPS"
+CION_TSIG_GRANT="grant * selfwild * ${CION_TSIG_TYPES};"
+
 #
 # Display settings on standard out.
 #
@@ -115,13 +123,31 @@ else
         echo "  type master;"
         echo "  file \"${zonefile}\";"
         echo "  allow-transfer { 127.0.0.1; ${CION_NS2_ADDRESS}; };"
-        echo "  allow-update { key rndc-key; };"
+        echo "  update-policy {"
+        echo "    grant rndc-key zonesub ANY;"
+        echo "    ${CION_TSIG_GRANT}"
+        echo "  };"
         echo "  notify yes;"
         echo "};"
     ) > "${configfile}"
     chown named. "${configfile}"
 fi
 
+if grep -q "allow-update" "${configfile}"; then
+    echo "Migrating root-domain configfile to update-policy..."
+    sed -i \
+        -e "s/^\\(\\s*\\)allow-update { key rndc-key; };/\\1update-policy {\\n\\1  grant rndc-key zonesub ANY;\\n\\1  ${CION_TSIG_GRANT}\\n\\1};/" \
+        "${configfile}"
+fi
+
+echo "Checking for zone keys file..."
+zonekeysfile="${CION_CONF_ROOT}/named.conf.zonekeys"
+if [[ ! -f "${zonekeysfile}" ]]; then
+    echo "Creating empty zone keys file... [${zonekeysfile}]"
+    echo "# Generated by cion, do not edit." > "${zonekeysfile}"
+    chown named. "${zonekeysfile}"
+fi
+
 #
 # Generate RNDC key
 #
@@ -1,14 +1,16 @@
 package middleware
 
 import (
-	"errors"
+	"bytes"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/baccenfutter/cion/config"
+	"github.com/baccenfutter/cion/authkey"
 	"github.com/labstack/echo"
 )
 
@@ -19,45 +21,125 @@ type (
 		AuthKey    string `json:"auth_key"`
 		UpdateType string `json:"update_type"`
 		DeleteType string `json:"delete_type"`
-		Debug      bool   `json:"debug"`
+		// KeyID identifies which of the keys of the zone authenticated the
+		// request, Scope holds its restrictions.
+		KeyID string         `json:"key_id"`
+		Scope *authkey.Scope `json:"scope,omitempty"`
+		// Token reports whether the request was authenticated with an access
+		// token rather than a key. AuthKey holds zone/KeyID for tokens and
+		// signed requests, they never carry the key itself.
+		Token bool `json:"token"`
+		// DryRun requests to validate changes without applying them.
+		DryRun bool `json:"dry_run"`
+	}
+
+	// CionConfig defines the config for Cion middleware.
+	CionConfig struct {
+		// Keys holds the auth keys of all zones.
+		Keys *authkey.Store
+
+		// Tokens verifies access tokens passed as Authorization: Bearer.
+		// Without it, only auth keys are accepted.
+		Tokens *authkey.Tokens
+
+		// MaxSkew is the maximum difference between the timestamp of signed
+		// requests and the time they are received.
+		// Optional. Default value 5 minutes.
+		MaxSkew time.Duration
 	}
 )
 
-// Cion returns the cion middleware.
-func Cion() echo.MiddlewareFunc {
+const (
+	// DefaultMaxSkew is the default MaxSkew of CionConfig.
+	DefaultMaxSkew = 5 * time.Minute
+
+	// maxSignedBody limits the size of the bodies of signed requests.
+	maxSignedBody = 1 << 20
+)
+
+// validNonce matches the nonces of signed requests.
+var validNonce = regexp.MustCompile(`^[a-zA-Z0-9_\-]{16,128}$`)
+
+// CionWithConfig returns a Cion middleware with config.
+//
+// Requests are authenticated by one of
+//   - an auth key of the zone in the X-Cion-Auth-Key header
+//   - a signature made with an auth key in the X-Cion-Signature header, see
+//     authkey.StringToSign
+//   - an access token in the Authorization: Bearer header
+func CionWithConfig(config CionConfig) echo.MiddlewareFunc {
+	// Defaults
+	if config.MaxSkew == 0 {
+		config.MaxSkew = DefaultMaxSkew
+	}
+	// A nonce has to be remembered for as long as its timestamp is accepted,
+	// which is up to MaxSkew into the future.
+	nonces := authkey.NewNonceCache(2 * config.MaxSkew)
+
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			headers := CionHeaders{}
 
+			username := c.Param("zone")
 			authKey := c.Request().Header.Get("x-cion-auth-key")
-			if authKey == "" {
-				return echo.NewHTTPError(
-					http.StatusUnauthorized,
-					"Please specify X-Cion-Auth-Key header!")
-			}
+			signature := c.Request().Header.Get("x-cion-signature")
+			token := bearerToken(c.Request())
+			if signature != "" {
+				key, err := verifySignature(c, config, nonces, username, signature)
+				if err != nil {
+					return err
+				}
 
-			// authenticate the user
-			username := c.Param("zone")
-			err := authenticate(username, []byte(authKey))
-			if err != nil {
-				return echo.NewHTTPError(http.StatusUnauthorized, err)
+				headers.AuthKey = username + "/" + key.ID
+				headers.KeyID = key.ID
+				headers.Scope = key.Scope
+			} else if authKey == "" && token != "" && config.Tokens != nil {
+				// authenticate the token, it is only valid as long as the
+				// key it was issued for
+				claims, err := config.Tokens.Parse(token)
+				if err != nil || claims.Zone != username {
+					return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+				}
+				if _, ok := config.Keys.Key(username, claims.KeyID); !ok {
+					return echo.NewHTTPError(http.StatusUnauthorized, "token revoked")
+				}
+
+				headers.AuthKey = username + "/" + claims.KeyID
+				headers.KeyID = claims.KeyID
+				headers.Scope = claims.Scope
+				headers.Token = true
+			} else {
+				if authKey == "" {
+					return echo.NewHTTPError(
+						http.StatusUnauthorized,
+						"Please specify X-Cion-Auth-Key header!")
+				}
+
+				// authenticate the user
+				key, ok := config.Keys.Identify(username, []byte(authKey))
+				if !ok {
+					return echo.NewHTTPError(http.StatusUnauthorized, "authentication failed")
+				}
+
+				headers.AuthKey = authKey
+				headers.KeyID = key.ID
+				headers.Scope = key.Scope
 			}
 
-			// Add authkey and zone to cion headers.
-			headers.AuthKey = authKey
+			// Add zone to cion headers.
 			headers.Zone = username
 
 			// Add x-cion-update-type header if present.
 			headers.UpdateType = c.Request().Header.Get("x-cion-update-type")
-			log.Println("UPDATE-TYPE:", headers.UpdateType)
 
 			// Add x-cion-delete-type header if present.
 			headers.DeleteType = c.Request().Header.Get("x-cion-delete-type")
-			log.Println("DELETE-TYPE:", headers.UpdateType)
 
-			mode := c.Request().Header.Get("x-cion-mode")
-			if strings.ToLower(mode) == "debug" {
-				headers.Debug = true
+			if strings.EqualFold(c.Request().Header.Get("x-cion-mode"), "dry-run") {
+				headers.DryRun = true
+			}
+			if dryRun, err := strconv.ParseBool(c.QueryParam("dry_run")); err == nil && dryRun {
+				headers.DryRun = true
 			}
 
 			c.Set("cion_headers", headers)
@@ -66,17 +148,51 @@ func Cion() echo.MiddlewareFunc {
 	}
 }
 
-// authenticate takes a username and a key and returns an error if the user can
-// not be authenticated successfully.
-func authenticate(username string, authKey []byte) error {
-	keyDir := config.Config().KeyDir
-	filePath := filepath.Join(keyDir, string(username)+".key")
-	key, err := ioutil.ReadFile(filePath)
+// bearerToken returns the token of an Authorization: Bearer header.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get(echo.HeaderAuthorization)
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// verifySignature authenticates a signed request. The body is read and
+// replaced, so that handlers can read it again.
+func verifySignature(c echo.Context, config CionConfig, nonces *authkey.NonceCache, zone, signature string) (authkey.Info, error) {
+	r := c.Request()
+
+	timestamp := r.Header.Get("x-cion-timestamp")
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
 	if err != nil {
-		return err
+		return authkey.Info{}, echo.NewHTTPError(http.StatusUnauthorized, "Please specify X-Cion-Timestamp header!")
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > config.MaxSkew || skew < -config.MaxSkew {
+		return authkey.Info{}, echo.NewHTTPError(http.StatusUnauthorized, "request timestamp out of range")
+	}
+	nonce := r.Header.Get("x-cion-nonce")
+	if !validNonce.MatchString(nonce) {
+		return authkey.Info{}, echo.NewHTTPError(http.StatusUnauthorized, "Please specify X-Cion-Nonce header!")
 	}
-	if string(key) != string(authKey) {
-		return errors.New("authentication failed")
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+	if err != nil {
+		return authkey.Info{}, echo.NewHTTPError(http.StatusBadRequest, "can not read body")
+	}
+	if len(body) > maxSignedBody {
+		return authkey.Info{}, echo.NewHTTPError(http.StatusRequestEntityTooLarge, "body too large")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	msg := authkey.StringToSign(timestamp, nonce, r.Method, r.URL.RequestURI(), body)
+	key, ok := config.Keys.VerifySignature(zone, r.Header.Get("x-cion-key-id"), msg, signature)
+	if !ok {
+		return authkey.Info{}, echo.NewHTTPError(http.StatusUnauthorized, "authentication failed")
+	}
+
+	// Only remember nonces of valid signatures, others could flood the cache.
+	if !nonces.Use(zone + "/" + nonce) {
+		return authkey.Info{}, echo.NewHTTPError(http.StatusUnauthorized, "nonce already used")
 	}
-	return nil
+	return key, nil
 }
@@ -0,0 +1,231 @@
+// Package nsupdate implements a minimal RFC 2136 DNS UPDATE client.
+package nsupdate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fudge is the permitted clock skew of TSIG signatures in seconds.
+const fudge = 300
+
+var (
+	// ErrConflict is returned if the prerequisites of an update were not met,
+	// i.e. the records have been modified since they were read.
+	ErrConflict = errors.New("nsupdate: records have been modified concurrently")
+)
+
+// Client sends signed DNS UPDATE messages for a single zone to a nameserver.
+type Client struct {
+	// Server is the address of the nameserver, e.g. 127.0.0.1:53.
+	Server string
+	// Zone is the name of the zone all updates are sent for.
+	Zone string
+	// Key is the TSIG key updates are signed with. It may be nil.
+	Key *Key
+
+	client *dns.Client
+}
+
+// NewClient returns a new Client.
+func NewClient(server, zone string, key *Key) *Client {
+	client := &dns.Client{
+		Net:     "tcp",
+		Timeout: 10 * time.Second,
+	}
+	if key != nil {
+		client.TsigSecret = map[string]string{key.Name: key.Secret}
+	}
+	return &Client{
+		Server: server,
+		Zone:   dns.Fqdn(zone),
+		Key:    key,
+		client: client,
+	}
+}
+
+// Lookup queries the nameserver for the RRset of the given name and type.
+// A non-existing RRset results in an empty slice.
+//
+// At and below a delegation within the zone, the nameserver answers with a
+// referral instead, which does not necessarily carry the complete RRset. The
+// RRsets of delegations and their glue are read from a zone transfer then.
+func (c *Client) Lookup(name string, rrtype uint16) ([]dns.RR, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), rrtype)
+	m.RecursionDesired = false
+
+	r, _, err := c.client.Exchange(m, c.Server)
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("nsupdate: lookup failed: %s", dns.RcodeToString[r.Rcode])
+	}
+	if referral(r) {
+		all, err := c.Transfer()
+		if err != nil {
+			return nil, err
+		}
+		return lookup(all, name, rrtype), nil
+	}
+	return lookup(r.Answer, name, rrtype), nil
+}
+
+// referral reports whether r refers to the nameservers of a delegation
+// rather than answering authoritatively.
+func referral(r *dns.Msg) bool {
+	if r.Authoritative || len(r.Answer) > 0 {
+		return false
+	}
+	for _, rr := range r.Ns {
+		if rr.Header().Rrtype == dns.TypeNS {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the records of rrs of the given name and type.
+func lookup(rrs []dns.RR, name string, rrtype uint16) []dns.RR {
+	return filter(rrs, &dns.ANY{Hdr: dns.RR_Header{Name: dns.Fqdn(name), Rrtype: rrtype}})
+}
+
+// Transfer fetches all records of the zone via AXFR. The SOA record, which
+// is sent twice in each transfer, is only returned once.
+func (c *Client) Transfer() ([]dns.RR, error) {
+	m := new(dns.Msg)
+	m.SetAxfr(c.Zone)
+
+	t := new(dns.Transfer)
+	if c.Key != nil {
+		m.SetTsig(c.Key.Name, dns.Fqdn(c.Key.Algorithm), fudge, time.Now().Unix())
+		t.TsigSecret = c.client.TsigSecret
+	}
+
+	env, err := t.In(m, c.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	rrs := []dns.RR{}
+	for e := range env {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		rrs = append(rrs, e.RR...)
+	}
+	if len(rrs) > 1 && rrs[len(rrs)-1].Header().Rrtype == dns.TypeSOA {
+		rrs = rrs[:len(rrs)-1]
+	}
+	return rrs, nil
+}
+
+// Replace atomically replaces the RRsets in old with the records in rrs.
+//
+// The old records have to contain the complete RRsets as they were read from
+// the nameserver. They are sent as prerequisites, so that the update is
+// rejected with ErrConflict if any of the RRsets has changed meanwhile. All
+// RRsets touched by rrs but absent from old are required not to exist.
+func (c *Client) Replace(old, rrs []dns.RR) error {
+	m := new(dns.Msg)
+	m.SetUpdate(c.Zone)
+
+	for _, set := range rrsets(old, rrs) {
+		cur := filter(old, set)
+		if len(cur) == 0 {
+			m.RRsetNotUsed([]dns.RR{set})
+		} else {
+			m.Used(copyRRs(cur))
+		}
+		m.RemoveRRset([]dns.RR{set})
+	}
+	m.Insert(copyRRs(rrs))
+
+	return c.exchange(m)
+}
+
+// Add adds the given records to their RRsets.
+func (c *Client) Add(rrs ...dns.RR) error {
+	m := new(dns.Msg)
+	m.SetUpdate(c.Zone)
+	m.Insert(copyRRs(rrs))
+	return c.exchange(m)
+}
+
+// Remove removes the given records from their RRsets.
+func (c *Client) Remove(rrs ...dns.RR) error {
+	m := new(dns.Msg)
+	m.SetUpdate(c.Zone)
+	m.Remove(copyRRs(rrs))
+	return c.exchange(m)
+}
+
+func (c *Client) exchange(m *dns.Msg) error {
+	if c.Key != nil {
+		m.SetTsig(c.Key.Name, dns.Fqdn(c.Key.Algorithm), fudge, time.Now().Unix())
+	}
+
+	r, _, err := c.client.Exchange(m, c.Server)
+	if err != nil {
+		return err
+	}
+
+	switch r.Rcode {
+	case dns.RcodeSuccess:
+		return nil
+	case dns.RcodeYXDomain, dns.RcodeNameError, dns.RcodeYXRrset, dns.RcodeNXRrset:
+		return ErrConflict
+	}
+	return fmt.Errorf("nsupdate: update failed: %s", dns.RcodeToString[r.Rcode])
+}
+
+// rrsets returns one header-only record for every distinct RRset in rrs.
+func rrsets(rrs ...[]dns.RR) []dns.RR {
+	sets := []dns.RR{}
+	seen := map[string]bool{}
+	for _, list := range rrs {
+		for _, rr := range list {
+			hdr := rr.Header()
+			key := dns.CanonicalName(hdr.Name) + "/" + dns.TypeToString[hdr.Rrtype]
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			sets = append(sets, &dns.ANY{Hdr: dns.RR_Header{
+				Name:   hdr.Name,
+				Rrtype: hdr.Rrtype,
+				Class:  dns.ClassINET,
+			}})
+		}
+	}
+	return sets
+}
+
+// filter returns all records of rrs which belong to the RRset of set.
+func filter(rrs []dns.RR, set dns.RR) []dns.RR {
+	out := []dns.RR{}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != set.Header().Rrtype {
+			continue
+		}
+		if dns.CanonicalName(rr.Header().Name) != dns.CanonicalName(set.Header().Name) {
+			continue
+		}
+		out = append(out, rr)
+	}
+	return out
+}
+
+// copyRRs returns deep copies of rrs, since building update messages modifies
+// the record headers.
+func copyRRs(rrs []dns.RR) []dns.RR {
+	out := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		out[i] = dns.Copy(rr)
+	}
+	return out
+}
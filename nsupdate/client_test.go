@@ -0,0 +1,104 @@
+package nsupdate
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// testZone holds the records served by newTestServer, with a delegation of
+// sub.example.test.
+var testZone = []string{
+	"example.test. 3600 IN SOA ns1.example.test. hostmaster.example.test. 1 3600 600 86400 180",
+	"example.test. 3600 IN NS ns1.example.test.",
+	"ns1.example.test. 3600 IN A 192.0.2.1",
+	"www.example.test. 180 IN A 192.0.2.2",
+	"sub.example.test. 180 IN NS ns1.sub.example.test.",
+	"sub.example.test. 180 IN NS ns2.sub.example.test.",
+	"ns1.sub.example.test. 180 IN A 192.0.2.53",
+	"ns2.sub.example.test. 180 IN A 192.0.2.54",
+}
+
+// newTestServer starts a nameserver for testZone, which answers like BIND:
+// names at and below the delegation are referred to its nameservers, with
+// only part of the glue. The returned function stops it.
+func newTestServer(t *testing.T) (string, func()) {
+	t.Helper()
+	rrs := []dns.RR{}
+	for _, s := range testZone {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	cut := "sub.example.test."
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		q := req.Question[0]
+		m := new(dns.Msg)
+		m.SetReply(req)
+
+		switch {
+		case q.Qtype == dns.TypeAXFR:
+			m.Answer = append(rrs, rrs[0])
+		case dns.IsSubDomain(cut, q.Name):
+			m.Ns = lookup(rrs, cut, dns.TypeNS)
+			m.Extra = lookup(rrs, "ns1."+cut, dns.TypeA)
+		default:
+			m.Authoritative = true
+			m.Answer = lookup(rrs, q.Name, q.Qtype)
+			if len(m.Answer) == 0 {
+				m.Ns = rrs[:1]
+			}
+		}
+		w.WriteMsg(m)
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan bool)
+	srv := &dns.Server{Listener: l, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go srv.ActivateAndServe()
+	<-started
+	return l.Addr().String(), func() { srv.Shutdown() }
+}
+
+func TestClientLookup(t *testing.T) {
+	addr, stop := newTestServer(t)
+	defer stop()
+	c := NewClient(addr, "example.test", nil)
+
+	tests := []struct {
+		name   string
+		rrtype uint16
+		want   []string
+	}{
+		{"www.example.test", dns.TypeA, []string{"192.0.2.2"}},
+		{"missing.example.test", dns.TypeA, nil},
+		{"example.test", dns.TypeNS, []string{"ns1.example.test."}},
+		{"sub.example.test", dns.TypeNS, []string{"ns1.sub.example.test.", "ns2.sub.example.test."}},
+		{"ns1.sub.example.test", dns.TypeA, []string{"192.0.2.53"}},
+		{"ns2.sub.example.test", dns.TypeA, []string{"192.0.2.54"}},
+		{"sub.example.test", dns.TypeTXT, nil},
+	}
+	for _, tt := range tests {
+		rrs, err := c.Lookup(tt.name, tt.rrtype)
+		if err != nil {
+			t.Fatalf("%s %s: %s", tt.name, dns.TypeToString[tt.rrtype], err)
+		}
+		got := []string{}
+		for _, rr := range rrs {
+			got = append(got, strings.TrimPrefix(rr.String(), rr.Header().String()))
+		}
+		sort.Strings(got)
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s %s: got %v, want %v", tt.name, dns.TypeToString[tt.rrtype], got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package nsupdate
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Key is a TSIG key used for signing DNS UPDATE messages.
+type Key struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm"`
+	Secret    string `json:"secret"`
+}
+
+var (
+	keyName      = regexp.MustCompile(`key\s+"?([^"\s{]+)"?\s*\{`)
+	keyAlgorithm = regexp.MustCompile(`algorithm\s+"?([^";\s]+)"?\s*;`)
+	keySecret    = regexp.MustCompile(`secret\s+"([^"]+)"\s*;`)
+)
+
+// LoadKey reads the first key statement from a named.conf style file, as
+// written by tsig-keygen or rndc-confgen.
+func LoadKey(path string) (*Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKey(string(data))
+}
+
+// ParseKey parses the first key statement of a named.conf snippet.
+func ParseKey(conf string) (*Key, error) {
+	name := keyName.FindStringSubmatch(conf)
+	algorithm := keyAlgorithm.FindStringSubmatch(conf)
+	secret := keySecret.FindStringSubmatch(conf)
+	if name == nil || algorithm == nil || secret == nil {
+		return nil, errors.New("nsupdate: no valid key statement found")
+	}
+	return &Key{
+		Name:      dns.CanonicalName(name[1]),
+		Algorithm: strings.TrimSuffix(strings.ToLower(algorithm[1]), "."),
+		Secret:    secret[1],
+	}, nil
+}
+
+// GenerateKey returns a new random HMAC-SHA256 key with the given name.
+func GenerateKey(name string) (*Key, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return &Key{
+		Name:      dns.CanonicalName(name),
+		Algorithm: "hmac-sha256",
+		Secret:    base64.StdEncoding.EncodeToString(secret),
+	}, nil
+}
+
+// String returns the key as a named.conf key statement.
+func (k *Key) String() string {
+	return fmt.Sprintf(
+		"key \"%s\" {\n\talgorithm %s;\n\tsecret \"%s\";\n};\n",
+		k.Name, k.Algorithm, k.Secret,
+	)
+}